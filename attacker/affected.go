@@ -0,0 +1,152 @@
+package attacker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Packages and distributions synthetic vulnerabilities are made of, as found in common base images.
+var (
+	syntheticPackages = []string{
+		"openssl", "libssl3", "glibc", "libc6", "zlib1g", "curl", "libcurl4", "bash", "coreutils", "tar",
+		"gzip", "xz-utils", "libxml2", "sqlite3", "perl-base", "python3.10", "systemd", "util-linux", "openssh-client", "gnupg",
+	}
+	syntheticDistributions = []map[string]string{
+		{"did": "ubuntu", "name": "Ubuntu", "version": "22.04 (Jammy)", "version_code_name": "jammy", "version_id": "22.04", "pretty_name": "Ubuntu 22.04 LTS"},
+		{"did": "ubuntu", "name": "Ubuntu", "version": "20.04 (Focal)", "version_code_name": "focal", "version_id": "20.04", "pretty_name": "Ubuntu 20.04 LTS"},
+		{"did": "debian", "name": "Debian GNU/Linux", "version": "12 (bookworm)", "version_code_name": "bookworm", "version_id": "12", "pretty_name": "Debian GNU/Linux 12 (bookworm)"},
+		{"did": "rhel", "name": "Red Hat Enterprise Linux Server", "version": "9", "version_id": "9", "pretty_name": "Red Hat Enterprise Linux Server 9"},
+	}
+	syntheticSeverities = []string{"Low", "Medium", "High", "Critical"}
+)
+
+// SyntheticVulnerabilities makes up vulnerabilities shaped like the ones clair's updaters
+// store, against packages and distributions found in common base images.
+// It returns the vulnerabilities as JSON documents.
+func SyntheticVulnerabilities(n int) []json.RawMessage {
+	issued := time.Now().UTC().Truncate(time.Second)
+	vulns := make([]json.RawMessage, 0, n)
+	for i := 0; i < n; i++ {
+		pkg := syntheticPackages[rand.Intn(len(syntheticPackages))]
+		dist := syntheticDistributions[rand.Intn(len(syntheticDistributions))]
+		name := fmt.Sprintf("CVE-%d-%05d", issued.Year(), 10000+rand.Intn(90000))
+		fixed := fmt.Sprintf("%d.%d.%d-%d", 1+rand.Intn(3), rand.Intn(20), rand.Intn(20), 1+rand.Intn(5))
+		severity := syntheticSeverities[rand.Intn(len(syntheticSeverities))]
+		v, _ := json.Marshal(map[string]interface{}{
+			"id":                  strconv.Itoa(1000000 + i),
+			"updater":             dist["did"] + "-updater",
+			"name":                name,
+			"description":         fmt.Sprintf("Synthetic vulnerability %s in %s", name, pkg),
+			"issued":              issued.Format(time.RFC3339),
+			"links":               "https://www.cve.org/CVERecord?id=" + name,
+			"severity":            severity,
+			"normalized_severity": severity,
+			"package": map[string]interface{}{
+				"id":      "",
+				"name":    pkg,
+				"version": "",
+				"kind":    "binary",
+			},
+			"distribution": map[string]interface{}{
+				"id":                "",
+				"did":               dist["did"],
+				"name":              dist["name"],
+				"version":           dist["version"],
+				"version_code_name": dist["version_code_name"],
+				"version_id":        dist["version_id"],
+				"pretty_name":       dist["pretty_name"],
+			},
+			"repository":       map[string]interface{}{},
+			"fixed_in_version": fixed,
+		})
+		vulns = append(vulns, v)
+	}
+	return vulns
+}
+
+// HarvestVulnerabilities fetches the vulnerability reports of the manifests at the configured rate
+// and gathers every vulnerability found in them, once each.
+// It returns the vulnerabilities as JSON documents and an error if none could be gathered.
+func HarvestVulnerabilities(ctx context.Context, manifestHashes []string, conf *AttackConfig) ([]json.RawMessage, error) {
+	if len(manifestHashes) == 0 {
+		return nil, fmt.Errorf("no manifests to harvest vulnerabilities from")
+	}
+	requests := generateVegetaRequests(GetVulnerabilityReportRequests(ctx, manifestHashes, conf.Host, ""))
+	targeter := vegeta.NewStaticTargeter(requests...)
+	if conf.Auth != nil {
+		targeter = authTargeter(targeter, conf.Auth.Token)
+	}
+	plan := Plan{Rate: conf.Concurrency, Hits: uint64(len(requests))}
+	results, err := attack(ctx, targeter, plan, conf.Transport, "harvest_vulnerabilities")
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]json.RawMessage{}
+	var failed int
+	for res := range results {
+		if res.Code != http.StatusOK {
+			failed++
+			continue
+		}
+		var report struct {
+			Vulnerabilities map[string]json.RawMessage `json:"vulnerabilities"`
+		}
+		if err := json.Unmarshal(res.Body, &report); err != nil {
+			failed++
+			continue
+		}
+		for id, v := range report.Vulnerabilities {
+			found[id] = v
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	vulns := make([]json.RawMessage, 0, len(ids))
+	for _, id := range ids {
+		vulns = append(vulns, found[id])
+	}
+	zlog.Info(ctx).Int("manifests", len(manifestHashes)).Int("failed", failed).Int("vulnerabilities", len(vulns)).Msg("Harvested vulnerabilities")
+	if len(vulns) == 0 {
+		return nil, fmt.Errorf("no vulnerability found in the reports of %d manifests", len(manifestHashes))
+	}
+	return vulns, nil
+}
+
+// AffectedManifestRequests returns the list of requests to perform POST operation on affected_manifest,
+// every one of them carrying a batch of batchSize vulnerabilities taken in turn from vulns.
+func AffectedManifestRequests(ctx context.Context, vulns []json.RawMessage, batches, batchSize int, host, token string) []map[string]interface{} {
+	zlog.Info(ctx).Int("number of requests", batches).Int("batch size", batchSize).Msg("preparing requests for POST operation in affected_manifest")
+	url, headers := getRequestCommons(ctx, "/indexer/api/v1/internal/affected_manifest", host, token)
+	var requests []map[string]interface{}
+	next := 0
+	for b := 0; b < batches && len(vulns) > 0; b++ {
+		batch := make([]json.RawMessage, 0, batchSize)
+		for len(batch) < batchSize {
+			batch = append(batch, vulns[next%len(vulns)])
+			next++
+		}
+		body, _ := json.Marshal(map[string]interface{}{"vulnerabilities": batch})
+		requests = append(requests, map[string]interface{}{
+			"method": http.MethodPost,
+			"url":    url,
+			"header": headers,
+			"body":   body,
+		})
+	}
+	return requests
+}
@@ -4,10 +4,17 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/quay/zlog"
 )
 
+// Paths of the matcher's internal endpoints
+const (
+	updateOperationPath = "/matcher/api/v1/internal/update_operation"
+	updateDiffPath      = "/matcher/api/v1/internal/update_diff"
+)
+
 // getRequestCommons returns the common inputs for each and every HTTP request.
 // It returns a url and headers for the specified input.
 func getRequestCommons(ctx context.Context, endpoint, host, token string) (string, http.Header) {
@@ -95,3 +102,36 @@ func GetIndexerStateRequests(ctx context.Context, hitsize int, host, token strin
 	}
 	return requests
 }
+
+// GetUpdateOperationRequests returns the list of requests to perform GET operation on update_operation.
+func GetUpdateOperationRequests(ctx context.Context, hitsize int, host, token string) []map[string]interface{} {
+	zlog.Info(ctx).Int("number of requests", hitsize).Msg("preparing requests for GET operation in update_operation")
+	url, headers := getRequestCommons(ctx, updateOperationPath, host, token)
+	var requests []map[string]interface{}
+	for i := 0; i < hitsize; i++ {
+		requests = append(requests, map[string]interface{}{
+			"method": http.MethodGet,
+			"url":    url,
+			"header": headers,
+		})
+	}
+	return requests
+}
+
+// GetUpdateDiffRequests returns the list of requests to perform GET operation on update_diff.
+func GetUpdateDiffRequests(ctx context.Context, diffs []UpdateDiff, host, token string) []map[string]interface{} {
+	zlog.Info(ctx).Int("number of requests", len(diffs)).Msg("preparing requests for GET operation in update_diff")
+	endpoint, headers := getRequestCommons(ctx, updateDiffPath, host, token)
+	var requests []map[string]interface{}
+	for _, diff := range diffs {
+		q := url.Values{}
+		q.Set("cur", diff.Cur)
+		q.Set("prev", diff.Prev)
+		requests = append(requests, map[string]interface{}{
+			"method": http.MethodGet,
+			"url":    endpoint + "?" + q.Encode(),
+			"header": headers,
+		})
+	}
+	return requests
+}
@@ -0,0 +1,23 @@
+package attacker
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestGetUpdateDiffRequests(t *testing.T) {
+	diffs := []UpdateDiff{{Cur: "a&b=c", Prev: "d e"}}
+	requests := GetUpdateDiffRequests(context.Background(), diffs, "http://clair", "")
+	u, err := url.Parse(requests[0]["url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != updateDiffPath {
+		t.Errorf("got path %s, want %s", u.Path, updateDiffPath)
+	}
+	q := u.Query()
+	if q.Get("cur") != "a&b=c" || q.Get("prev") != "d e" || len(q) != 2 {
+		t.Errorf("got query %v, want the references escaped", q)
+	}
+}
@@ -5,14 +5,22 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
 	"time"
 
-	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/quay/clair-load-test/auth"
+	"github.com/quay/clair-load-test/clairmetrics"
+	"github.com/quay/clair-load-test/faultproxy"
+	"github.com/quay/clair-load-test/redact"
 	"github.com/quay/zlog"
 	vegeta "github.com/tsenart/vegeta/v12/lib"
 )
 
+// Constants
+const (
+	requestTimeout  = 6000 * time.Second
+	timestampFormat = "2006-01-02T15:04:05.999999Z07:00"
+)
+
 // generateVegetaRequests generates requests which can be fed as input to vegeta for HTTP benchmarking.
 // It return a consolidated targets list which has all the requests fed all at once to vegeta.
 func generateVegetaRequests(requestDicts []map[string]interface{}) []vegeta.Target {
@@ -49,33 +57,24 @@ func generateVegetaRequests(requestDicts []map[string]interface{}) []vegeta.Targ
 	return targets
 }
 
-// indexVegetaResults to process vegeta output and index the results to elastic search.
-// It returns an error if any during the execution.
-func indexVegetaResults(ctx context.Context, metrics vegeta.Metrics, testName string, attackMap map[string]string) error {
-	var indexer *indexers.Indexer
-	indexerConfig := indexers.IndexerConfig{
-		Type:               "opensearch",
-		Servers:            []string{attackMap["ESHost"] + ":" + attackMap["ESPort"]},
-		Index:              attackMap["ESIndex"],
-		InsecureSkipVerify: true,
-	}
-	zlog.Info(ctx).Msg("Creating opensearch indexer")
-	indexer, err := indexers.NewIndexer(indexerConfig)
-	if err != nil {
-		return fmt.Errorf("Failure while connnecting to Elasticsearch: %w", err)
-	}
-	zlog.Info(ctx).Str("server", indexerConfig.Servers[0]).Msg("Connected")
-	concurrency, _ := strconv.Atoi(attackMap["Concurrency"])
+// newDocument summarises a finished phase.
+// It returns the document to be indexed.
+func newDocument(metrics *vegeta.Metrics, testName string, startTime, endTime time.Time, conf *AttackConfig) Document {
 	hostname, _ := os.Hostname()
-	zlog.Info(ctx).Str("es-index", attackMap["ESIndex"]).Msg("Indexing documents")
-	resp, err := (*indexer).Index([]interface{}{Document{
+	return Document{
+		SchemaVersion:  DocumentSchemaVersion,
 		Workload:       "clair-load-test",
-		Endpoint:       attackMap["Host"],
-		RequestTimeout: 120,
+		ToolVersion:    conf.ToolVersion,
+		ClairVersion:   conf.ClairVersion,
+		Endpoint:       conf.Host,
+		RequestTimeout: int(conf.Transport.Timeout.Seconds()),
 		Targets:        testName,
 		Hostname:       hostname,
-		RPS:            concurrency,
+		Config:         conf.TestConfig,
+		TargetRate:     conf.Concurrency,
+		AchievedRate:   metrics.Rate,
 		Throughput:     metrics.Throughput,
+		Success:        metrics.Success,
 		StatusCodes:    metrics.StatusCodes,
 		Requests:       metrics.Requests,
 		P99Latency:     metrics.Latencies.P99,
@@ -83,11 +82,22 @@ func indexVegetaResults(ctx context.Context, metrics vegeta.Metrics, testName st
 		MaxLatency:     metrics.Latencies.Max,
 		MinLatency:     metrics.Latencies.Min,
 		ReqLatency:     metrics.Latencies.Mean,
-		Timestamp:      time.Now().Format("2006-01-02T15:04:05.999999Z07:00"),
+		Timestamp:      endTime.Format(timestampFormat),
+		StartTime:      startTime.Format(timestampFormat),
+		EndTime:        endTime.Format(timestampFormat),
+		Duration:       endTime.Sub(startTime),
+		Errors:         metrics.Errors,
 		BytesIn:        metrics.BytesIn.Mean,
 		BytesOut:       metrics.BytesOut.Mean,
-		RunID:          attackMap["RUNID"],
-	}}, indexers.IndexingOpts{})
+		RunID:          conf.RUNID,
+	}
+}
+
+// indexVegetaResults to index the phase document to the configured indexer.
+// It returns an error if any during the execution.
+func indexVegetaResults(ctx context.Context, doc Document, conf *AttackConfig) error {
+	zlog.Info(ctx).Str("phase", doc.Targets).Msg("Indexing documents")
+	resp, err := conf.Indexer.Index(ctx, []interface{}{doc}, doc.Targets+"-"+conf.RUNID)
 	if err != nil {
 		return err
 	}
@@ -95,28 +105,326 @@ func indexVegetaResults(ctx context.Context, metrics vegeta.Metrics, testName st
 	return nil
 }
 
-// RunVegeta runs vegeta, records their results and indexes to elastic search if provided with connection details.
+// newAuthSummary separates the requests clair rejected with a 401 from the others and
+// counts the broken tokens handed out during the phase.
+// It returns the summary of the phase.
+func newAuthSummary(iss *auth.Issuer, injectedBefore map[string]uint64, rejected, accepted *vegeta.Metrics) *AuthSummary {
+	injected := iss.Injected()
+	for k, v := range injectedBefore {
+		injected[k] -= v
+	}
+	return &AuthSummary{
+		Scope:           iss.Scope(),
+		Injected:        injected,
+		Rejected:        rejected.Requests,
+		RejectedLatency: newLatencySummary(rejected),
+		AcceptedLatency: newLatencySummary(accepted),
+	}
+}
+
+// newLatencySummary picks the latencies of closed metrics.
+// It returns the latency summary.
+func newLatencySummary(m *vegeta.Metrics) LatencySummary {
+	return LatencySummary{
+		Mean: m.Latencies.Mean,
+		P50:  m.Latencies.P50,
+		P95:  m.Latencies.P95,
+		P99:  m.Latencies.P99,
+		Max:  m.Latencies.Max,
+	}
+}
+
+// Type used to keep the values of a cancelled context while ignoring its cancellation.
+type detached struct {
+	context.Context
+}
+
+// Deadline reports no deadline.
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+// Done returns a channel that is never closed.
+func (detached) Done() <-chan struct{} { return nil }
+
+// Err never reports an error.
+func (detached) Err() error { return nil }
+
+// Detach creates a context carrying the values of ctx but not its cancellation, so that
+// partial results can still be flushed and cleaned up once a run was interrupted.
+// It returns the detached context.
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+// Type used to stop an attack after an exact number of hits.
+type countPacer struct {
+	vegeta.Pacer
+	hits uint64
+}
+
+// Pace defers to the wrapped pacer until the planned hits have been sent.
+func (p countPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if p.hits > 0 && hits >= p.hits {
+		return 0, true
+	}
+	return p.Pacer.Pace(elapsed, hits)
+}
+
+// newPlan works out how many hits a phase sends and for how long.
+// Without an explicit count, every target is hit once unless only a duration was given,
+// or once per arrival of the trace for trace driven arrivals.
+// Exhausting targeters never hit a target twice.
+// It returns the plan of the phase.
+func newPlan(targets int, conf *AttackConfig) Plan {
+	plan := Plan{
+		Rate:     conf.Concurrency,
+		Hits:     conf.Requests,
+		Duration: conf.Duration,
+		Arrivals: conf.Arrivals,
+	}
+	if plan.Arrivals == ArrivalsTrace {
+		plan.Trace, plan.Speed = conf.Trace, conf.TraceSpeed
+		if plan.Hits == 0 || plan.Hits > uint64(len(plan.Trace)) {
+			plan.Hits = uint64(len(plan.Trace))
+		}
+	}
+	if plan.Hits == 0 && plan.Duration == 0 {
+		plan.Hits = uint64(targets)
+	}
+	if conf.Targeter == TargeterExhaust && (plan.Hits == 0 || plan.Hits > uint64(targets)) {
+		plan.Hits = uint64(targets)
+	}
+	return plan
+}
+
+// authTargeter sets the token handed out by next on every target, so that long attacks
+// keep working once the token they started with expired and every request can get its own.
+// It returns the wrapping targeter.
+func authTargeter(tr vegeta.Targeter, next func() (string, error)) vegeta.Targeter {
+	return func(tgt *vegeta.Target) error {
+		if err := tr(tgt); err != nil {
+			return err
+		}
+		tok, err := next()
+		if err != nil {
+			return err
+		}
+		tgt.Header = withToken(tgt.Header, tok)
+		return nil
+	}
+}
+
+// withToken copies the headers, setting the bearer token or removing it when empty.
+// It returns the new headers.
+func withToken(h http.Header, tok string) http.Header {
+	h = h.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	if tok == "" {
+		h.Del("Authorization")
+	} else {
+		h.Set("Authorization", "Bearer "+tok)
+	}
+	return h
+}
+
+// attack runs a vegeta attack following the plan, stopping it early if the context is cancelled.
+// It returns the channel the results are delivered on, closed once the attack is over,
+// and an error if the transport could not be set up.
+func attack(ctx context.Context, targeter vegeta.Targeter, plan Plan, transport Transport, name string) (<-chan *vegeta.Result, error) {
+	pacer := countPacer{Pacer: newPacer(plan), hits: plan.Hits}
+	return attackPaced(ctx, targeter, pacer, plan.Duration, transport, name)
+}
+
+// attackPaced runs a vegeta attack at the pace of the given pacer, for at most the given duration
+// when positive, stopping it early if the context is cancelled.
+// It returns the channel the results are delivered on, closed once the attack is over,
+// and an error if the transport could not be set up.
+func attackPaced(ctx context.Context, targeter vegeta.Targeter, pacer vegeta.Pacer, duration time.Duration, transport Transport, name string) (<-chan *vegeta.Result, error) {
+	transportOpts, err := transport.options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid transport settings: %w", err)
+	}
+	attacker := vegeta.NewAttacker(transportOpts...)
+	results := attacker.Attack(targeter, pacer, duration, name)
+	out := make(chan *vegeta.Result)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		defer close(done)
+		for res := range results {
+			out <- res
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			attacker.Stop()
+		case <-done:
+		}
+	}()
+	return out, nil
+}
+
+// RunVegeta runs vegeta, records their results and indexes them if an indexer is configured.
 // It returns an error if any during the execution.
-func RunVegeta(ctx context.Context, requestDicts []map[string]interface{}, testName string, attackMap map[string]string) error {
-	startTime := time.Now()
+// When ctx is cancelled the attack stops, and the results gathered so far are still
+// reported and indexed as an aborted phase.
+func RunVegeta(ctx context.Context, requestDicts []map[string]interface{}, testName string, conf *AttackConfig) error {
+	return runVegeta(ctx, requestDicts, testName, conf, nil)
+}
+
+// runVegeta runs the requests as a phase, letting observe, when set, see every result.
+// It returns an error if any during the execution.
+func runVegeta(ctx context.Context, requestDicts []map[string]interface{}, testName string, conf *AttackConfig, observe func(*vegeta.Result)) error {
 	requests := generateVegetaRequests(requestDicts)
-	concurrency, _ := strconv.Atoi(attackMap["Concurrency"])
-	rate := vegeta.Rate{Freq: concurrency, Per: time.Second}
-	duration := time.Second * time.Duration(len(requests)/concurrency)
-	targeter := vegeta.NewStaticTargeter(requests...)
-	attacker := vegeta.NewAttacker(vegeta.Timeout(6000 * time.Second))
+	plan := newPlan(len(requests), conf)
+	return runPhase(ctx, phase{
+		name:    testName,
+		plan:    plan,
+		observe: observe,
+		start: func(ctx context.Context) (<-chan *vegeta.Result, func() error, error) {
+			// Locally or across the workers
+			if len(conf.Workers) > 0 {
+				results, wait := runDistributed(ctx, requests, plan, testName, conf)
+				return results, wait, nil
+			}
+			targeter := vegeta.NewStaticTargeter(requests...)
+			if conf.Auth != nil {
+				targeter = authTargeter(targeter, conf.Auth.Next)
+			}
+			results, err := attack(ctx, targeter, plan, conf.Transport, testName)
+			return results, func() error { return nil }, err
+		},
+	}, conf)
+}
+
+// runPhase measures a phase: it starts its attack, records the results, then reports
+// and indexes them, along with the clair metrics, the faults injected by the proxy
+// and the samples when configured.
+// When ctx is cancelled the attack stops, and the results gathered so far are still
+// reported and indexed as an aborted phase.
+// It returns an error if any during the execution.
+func runPhase(ctx context.Context, p phase, conf *AttackConfig) error {
+	testName, plan := p.name, p.plan
+	if ctx.Err() != nil {
+		return fmt.Errorf("phase %s not started: %w", testName, context.Cause(ctx))
+	}
+	startTime := time.Now()
+	zlog.Info(ctx).
+		Str("phase", testName).
+		Int("rate", plan.Rate).
+		Uint64("planned_requests", plan.Hits).
+		Stringer("duration", plan.Duration).
+		Msg("Planned attack")
+
+	// Snapshot clair's own metrics before the attack
+	var before *clairmetrics.Snapshot
+	if conf.ClairMetricsURL != "" {
+		var err error
+		before, err = clairmetrics.Scrape(ctx, conf.ClairMetricsURL)
+		if err != nil {
+			zlog.Warn(ctx).Err(err).Str("phase", testName).Msg("could not snapshot clair metrics before the attack")
+		}
+	}
+	// And the faults injected by the proxy in between
+	var faultsBefore *faultproxy.Stats
+	if conf.ProxyStatsURL != "" {
+		var err error
+		faultsBefore, err = faultproxy.Scrape(ctx, conf.ProxyStatsURL)
+		if err != nil {
+			zlog.Warn(ctx).Err(err).Str("phase", testName).Msg("could not snapshot proxy stats before the attack")
+		}
+	}
+
+	// Index every single request in the background when asked to
+	var samples *sampleSink
+	if conf.Samples != nil {
+		samples = newSampleSink(Detach(ctx), conf, testName)
+	}
+
+	// Tokens are minted as soon as the attack starts, so count the faults injected so far first
+	var injectedBefore map[string]uint64
+	if conf.Auth != nil {
+		injectedBefore = conf.Auth.Injected()
+	}
 
 	// Initiate vegeta attack and stop immediately after completion
-	var metrics vegeta.Metrics
-	for res := range attacker.Attack(targeter, rate, duration, "Vegeta Attack") {
+	results, wait, err := p.start(ctx)
+	if err != nil {
+		if samples != nil {
+			samples.Close()
+		}
+		return err
+	}
+	var metrics, rejected, accepted vegeta.Metrics
+	for res := range results {
 		metrics.Add(res)
+		if res.Code == http.StatusUnauthorized {
+			rejected.Add(res)
+		} else {
+			accepted.Add(res)
+		}
+		if samples != nil {
+			samples.Add(res)
+		}
+		if conf.Soak != nil {
+			conf.Soak.Add(testName, res)
+		}
+		if p.observe != nil {
+			p.observe(res)
+		}
 	}
 
 	metrics.Close()
+	rejected.Close()
+	accepted.Close()
+	if samples != nil {
+		samples.Close()
+	}
+	// From here on, work on what was gathered even if the run is being interrupted
+	abortReason := context.Cause(ctx)
+	aborted := abortReason != nil
+	if err := wait(); err != nil && !aborted {
+		return fmt.Errorf("attack failure: %w", err)
+	}
+	if aborted {
+		zlog.Warn(ctx).Str("phase", testName).Uint64("requests", metrics.Requests).AnErr("reason", abortReason).Msg("Attack aborted, flushing partial results")
+		ctx = Detach(ctx)
+	}
+
+	// Snapshot clair's own metrics after the attack and compute the server side breakdown
+	var clairMetrics *clairmetrics.Delta
+	if before != nil {
+		after, err := clairmetrics.Scrape(ctx, conf.ClairMetricsURL)
+		if err != nil {
+			zlog.Warn(ctx).Err(err).Str("phase", testName).Msg("could not snapshot clair metrics after the attack")
+		} else {
+			clairMetrics = clairmetrics.Diff(before, after)
+			zlog.Info(ctx).
+				Int("counters", len(clairMetrics.Counters)).
+				Int("histograms", len(clairMetrics.Histograms)).
+				Msg(fmt.Sprintf("Collected clair metrics for %s", testName))
+		}
+	}
+
+	var faults *faultproxy.Stats
+	if faultsBefore != nil {
+		after, err := faultproxy.Scrape(ctx, conf.ProxyStatsURL)
+		if err != nil {
+			zlog.Warn(ctx).Err(err).Str("phase", testName).Msg("could not snapshot proxy stats after the attack")
+		} else {
+			faults = faultproxy.Diff(faultsBefore, after)
+		}
+	}
+
+	if plan.Hits > 0 && metrics.Requests != plan.Hits {
+		zlog.Warn(ctx).Str("phase", testName).Uint64("planned", plan.Hits).Uint64("actual", metrics.Requests).Msg("Attack did not send the planned requests")
+	}
 
 	// Generate Vegeta text report
 	report := vegeta.NewTextReporter(&metrics)
-	err := report.Report(os.Stdout)
+	err = report.Report(redact.NewWriter(os.Stdout))
 	if err != nil {
 		return fmt.Errorf("vegeta report command failure: %w", err)
 	}
@@ -125,12 +433,32 @@ func RunVegeta(ctx context.Context, requestDicts []map[string]interface{}, testN
 	elapsedTime := endTime.Sub(startTime)
 	zlog.Info(ctx).Stringer("duration", elapsedTime).Msg(fmt.Sprintf("Total time taken for %s", testName))
 
-	// Indexing results to elastic search
-	if attackMap["ESHost"] != "" && attackMap["ESPort"] != "" && attackMap["ESIndex"] != "" {
-		err = indexVegetaResults(ctx, metrics, testName, attackMap)
+	// Indexing results
+	if conf.Indexer != nil {
+		doc := newDocument(&metrics, testName, startTime, endTime, conf)
+		doc.PlannedRequests = plan.Hits
+		doc.ClairMetrics = clairMetrics
+		doc.Faults = faults
+		if samples != nil {
+			doc.SamplesDropped = samples.dropped
+		}
+		if conf.Auth != nil {
+			doc.Auth = newAuthSummary(conf.Auth, injectedBefore, &rejected, &accepted)
+		}
+		if aborted {
+			doc.Aborted = true
+			doc.AbortReason = abortReason.Error()
+		}
+		if p.decorate != nil {
+			p.decorate(&doc)
+		}
+		err = indexVegetaResults(ctx, doc, conf)
 		if err != nil {
-			return fmt.Errorf("Failed to indexing results to elastic search: %w", err)
+			return fmt.Errorf("Failed to index results: %w", err)
 		}
 	}
+	if aborted {
+		return fmt.Errorf("phase %s aborted: %w", testName, abortReason)
+	}
 	return nil
 }
@@ -0,0 +1,341 @@
+package attacker
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Constants
+const (
+	// missingManifest is probed for when no manifest is given, clair telling it does not know it is as good a sign of life
+	missingManifest     = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	missingNotification = "00000000-0000-0000-0000-000000000000"
+)
+
+// Type used to keep the outcome of a probe.
+type probe struct {
+	at     time.Time
+	code   uint16
+	failed bool
+}
+
+// Type used to follow a failure window while the probes are gone through.
+type outage struct {
+	start, end time.Time
+	open       bool
+	failures   uint64
+	codes      map[string]int
+	endpoints  map[string]bool
+}
+
+// Type used to drive the availability workload: it probes the endpoints in turn at a steady
+// pace until stopped, and keeps the outcome of every probe to find the failure windows.
+type availabilityRun struct {
+	pace   vegeta.Rate
+	ctx    context.Context
+	aconf  AvailabilityConfig
+	host   string
+	hashes []string
+
+	mu      sync.Mutex
+	next    uint64
+	probes  map[string][]probe
+	failing map[string]bool
+}
+
+// Pace keeps to the probing rate until ctx is done.
+func (r *availabilityRun) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if r.ctx.Err() != nil {
+		return 0, true
+	}
+	return r.pace.Pace(elapsed, hits)
+}
+
+// Rate returns the probing rate across the endpoints.
+func (r *availabilityRun) Rate(elapsed time.Duration) float64 {
+	return r.pace.Rate(elapsed)
+}
+
+// targeter builds the probes, going through the endpoints in turn and through the manifests
+// every round. The endpoint is kept in the URL fragment, which is never sent, to tell the results apart.
+// It returns an error if the token could not be issued.
+func (r *availabilityRun) targeter(conf *AttackConfig) vegeta.Targeter {
+	tr := func(tgt *vegeta.Target) error {
+		r.mu.Lock()
+		n := r.next
+		r.next++
+		r.mu.Unlock()
+		endpoint := r.aconf.Endpoints[n%uint64(len(r.aconf.Endpoints))]
+		hash := missingManifest
+		if len(r.hashes) > 0 {
+			hash = r.hashes[(n/uint64(len(r.aconf.Endpoints)))%uint64(len(r.hashes))]
+		}
+		var path string
+		switch endpoint {
+		case ProbeIndexReport:
+			path = "/indexer/api/v1/index_report/" + hash
+		case ProbeIndexState:
+			path = "/indexer/api/v1/index_state"
+		case ProbeVulnerabilityReport:
+			path = "/matcher/api/v1/vulnerability_report/" + hash
+		case ProbeUpdateOperation:
+			path = updateOperationPath
+		case ProbeNotification:
+			path = notificationPath + missingNotification
+		}
+		tgt.Method = http.MethodGet
+		tgt.URL = r.host + path + "#" + endpoint
+		tgt.Header = http.Header{}
+		return nil
+	}
+	if conf.Auth != nil {
+		return authTargeter(tr, conf.Auth.Next)
+	}
+	return tr
+}
+
+// observe keeps the outcome of a probe and logs the endpoints going down and coming back up.
+// A probe fails when clair could not be reached or answered with a server error, anything
+// else telling it is up.
+func (r *availabilityRun) observe(res *vegeta.Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		return
+	}
+	endpoint := u.Fragment
+	failed := res.Code == 0 || res.Code >= 500
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.probes[endpoint] = append(r.probes[endpoint], probe{at: res.Timestamp, code: res.Code, failed: failed})
+	switch {
+	case failed && !r.failing[endpoint]:
+		zlog.Warn(r.ctx).Str("endpoint", endpoint).Uint16("code", res.Code).Str("error", res.Error).Msg("Endpoint down")
+	case !failed && r.failing[endpoint]:
+		zlog.Info(r.ctx).Str("endpoint", endpoint).Uint16("code", res.Code).Msg("Endpoint back up")
+	}
+	r.failing[endpoint] = failed
+}
+
+// summary finds the failure windows of every endpoint, then the outages of clair as a whole,
+// during which any of its endpoints was down.
+// It returns the availability summary.
+func (r *availabilityRun) summary() AvailabilitySummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := AvailabilitySummary{Endpoints: map[string]EndpointAvailability{}, Outages: []FailureWindow{}}
+	var first, last time.Time
+	var all []outage
+	for _, endpoint := range r.aconf.Endpoints {
+		probes := r.probes[endpoint]
+		sort.Slice(probes, func(i, j int) bool { return probes[i].at.Before(probes[j].at) })
+		ea := EndpointAvailability{Probes: uint64(len(probes)), Windows: []FailureWindow{}}
+		if len(probes) == 0 {
+			s.Endpoints[endpoint] = ea
+			continue
+		}
+		if first.IsZero() || probes[0].at.Before(first) {
+			first = probes[0].at
+		}
+		if probes[len(probes)-1].at.After(last) {
+			last = probes[len(probes)-1].at
+		}
+		windows := failureWindows(endpoint, probes)
+		for _, w := range windows {
+			ea.Failures += w.failures
+			ea.Downtime += w.end.Sub(w.start)
+			ea.Windows = append(ea.Windows, w.window())
+		}
+		ea.LongestOutage = longestOutage(windows)
+		ea.Availability = availability(probes[len(probes)-1].at.Sub(probes[0].at), ea.Downtime, ea.Probes, ea.Failures)
+		s.Endpoints[endpoint] = ea
+		all = append(all, windows...)
+	}
+
+	outages := mergeOutages(all)
+	var probes, failures uint64
+	for _, ea := range s.Endpoints {
+		probes += ea.Probes
+		failures += ea.Failures
+	}
+	for _, o := range outages {
+		s.Downtime += o.end.Sub(o.start)
+		s.Outages = append(s.Outages, o.window())
+	}
+	s.LongestOutage = longestOutage(outages)
+	if !first.IsZero() {
+		s.Start, s.End = first.Format(timestampFormat), last.Format(timestampFormat)
+		s.Probed = last.Sub(first)
+	}
+	s.Availability = availability(s.Probed, s.Downtime, probes, failures)
+	return s
+}
+
+// decorate adds the availability summary to the document of the phase.
+func (r *availabilityRun) decorate(doc *Document) {
+	s := r.summary()
+	doc.Availability = &s
+	doc.TargetRate = int(math.Round(r.Rate(0)))
+}
+
+// failureWindows goes through the probes of an endpoint in order: a window opens with a failed
+// probe and closes with the next successful one.
+// It returns the windows, the last one open if the endpoint was still down when probing stopped.
+func failureWindows(endpoint string, probes []probe) []outage {
+	var windows []outage
+	var cur *outage
+	for _, p := range probes {
+		if !p.failed {
+			if cur != nil {
+				cur.end = p.at
+				windows = append(windows, *cur)
+				cur = nil
+			}
+			continue
+		}
+		if cur == nil {
+			cur = &outage{start: p.at, codes: map[string]int{}, endpoints: map[string]bool{endpoint: true}}
+		}
+		cur.failures++
+		cur.codes[strconv.Itoa(int(p.code))]++
+	}
+	if cur != nil {
+		cur.end = probes[len(probes)-1].at
+		cur.open = true
+		windows = append(windows, *cur)
+	}
+	return windows
+}
+
+// mergeOutages merges the overlapping failure windows of the endpoints.
+// It returns the outages in order.
+func mergeOutages(windows []outage) []outage {
+	sort.Slice(windows, func(i, j int) bool { return windows[i].start.Before(windows[j].start) })
+	var merged []outage
+	for _, w := range windows {
+		if n := len(merged); n > 0 && !w.start.After(merged[n-1].end) {
+			m := &merged[n-1]
+			if w.end.After(m.end) {
+				m.end = w.end
+			}
+			m.open = m.open || w.open
+			m.failures += w.failures
+			for code, count := range w.codes {
+				m.codes[code] += count
+			}
+			for e := range w.endpoints {
+				m.endpoints[e] = true
+			}
+			continue
+		}
+		m := outage{start: w.start, end: w.end, open: w.open, failures: w.failures, codes: map[string]int{}, endpoints: map[string]bool{}}
+		for code, count := range w.codes {
+			m.codes[code] = count
+		}
+		for e := range w.endpoints {
+			m.endpoints[e] = true
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// longestOutage returns the duration of the longest of the windows.
+func longestOutage(windows []outage) time.Duration {
+	var longest time.Duration
+	for _, w := range windows {
+		if d := w.end.Sub(w.start); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// availability returns the share of the probed time that was not down, as a percentage. With
+// nothing to measure time on, such as a single probe, it falls back to the share of probes that succeeded.
+func availability(probed, downtime time.Duration, probes, failures uint64) float64 {
+	if probed > 0 {
+		return 100 * (1 - float64(downtime)/float64(probed))
+	}
+	if probes == 0 {
+		return 0
+	}
+	return 100 * float64(probes-failures) / float64(probes)
+}
+
+// window describes the outage as it is indexed.
+func (o outage) window() FailureWindow {
+	w := FailureWindow{
+		Start:       o.start.Format(timestampFormat),
+		End:         o.end.Format(timestampFormat),
+		Duration:    o.end.Sub(o.start),
+		Open:        o.open,
+		Failures:    o.failures,
+		StatusCodes: o.codes,
+	}
+	for e := range o.endpoints {
+		w.Endpoints = append(w.Endpoints, e)
+	}
+	sort.Strings(w.Endpoints)
+	return w
+}
+
+// RunAvailability probes every configured endpoint of clair once per interval, spreading the
+// probes evenly, and works out when and how long clair was unavailable.
+// It stops after the configured duration or once ctx is done, which ends the measurement
+// rather than interrupting it, and then logs and indexes the failure windows.
+// It returns an error if any during the execution.
+func RunAvailability(ctx context.Context, hashes []string, aconf AvailabilityConfig, testName string, conf *AttackConfig) error {
+	warnLocal(ctx, testName, conf)
+	r := &availabilityRun{
+		pace:    vegeta.Rate{Freq: len(aconf.Endpoints), Per: aconf.Interval},
+		ctx:     ctx,
+		aconf:   aconf,
+		host:    conf.Host,
+		hashes:  hashes,
+		probes:  map[string][]probe{},
+		failing: map[string]bool{},
+	}
+	// Being stopped is how the measurement ends, the phase itself is not interrupted
+	err := runPhase(Detach(ctx), phase{
+		name: testName,
+		plan: Plan{Rate: int(math.Round(r.Rate(0))), Duration: aconf.Duration},
+		start: func(pctx context.Context) (<-chan *vegeta.Result, func() error, error) {
+			results, err := attackPaced(pctx, r.targeter(conf), r, aconf.Duration, conf.Transport, testName)
+			return results, func() error { return nil }, err
+		},
+		observe:  r.observe,
+		decorate: r.decorate,
+	}, conf)
+	if err != nil {
+		return err
+	}
+	s := r.summary()
+	for _, o := range s.Outages {
+		zlog.Warn(ctx).
+			Str("start", o.Start).
+			Str("end", o.End).
+			Stringer("duration", o.Duration).
+			Bool("open", o.Open).
+			Strs("endpoints", o.Endpoints).
+			Interface("status_codes", o.StatusCodes).
+			Msg("Outage")
+	}
+	zlog.Info(ctx).
+		Stringer("probed", s.Probed).
+		Float64("availability", s.Availability).
+		Stringer("downtime", s.Downtime).
+		Stringer("longest_outage", s.LongestOutage).
+		Int("outages", len(s.Outages)).
+		Msg("Availability measured")
+	return nil
+}
@@ -0,0 +1,54 @@
+package attacker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// CheckIndexReports looks up the index report of every manifest at the configured rate,
+// ahead of a run relying on manifests indexed earlier.
+// It returns the hashes clair has no index report for and an error if any lookup could not be answered.
+func CheckIndexReports(ctx context.Context, manifestHashes []string, conf *AttackConfig) ([]string, error) {
+	if len(manifestHashes) == 0 {
+		return nil, nil
+	}
+	requests := generateVegetaRequests(GetIndexReportRequests(ctx, manifestHashes, conf.Host, ""))
+	targeter := vegeta.NewStaticTargeter(requests...)
+	if conf.Auth != nil {
+		targeter = authTargeter(targeter, conf.Auth.Token)
+	}
+	plan := Plan{Rate: conf.Concurrency, Hits: uint64(len(requests))}
+	results, err := attack(ctx, targeter, plan, conf.Transport, "check_index_report")
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(manifestHashes))
+	var failed int
+	var lastErr string
+	for res := range results {
+		switch res.Code {
+		case http.StatusOK:
+			found[manifestHashOf(res)] = true
+		case http.StatusNotFound:
+		default:
+			failed++
+			lastErr = fmt.Sprintf("%d %s", res.Code, res.Error)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("%d index report lookups failed, last one with: %s", failed, lastErr)
+	}
+	var missing []string
+	for _, h := range manifestHashes {
+		if !found[h] {
+			missing = append(missing, h)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,65 @@
+package attacker
+
+import (
+	"context"
+	"net/http"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Cleanup deletes index reports at the configured rate, counting the ones clair no longer has as deleted.
+// Tokens are never broken on purpose here, whatever faults the run injects.
+// It returns the hashes that could not be deleted and an error if the deletion could not run.
+func Cleanup(ctx context.Context, manifestHashes []string, conf *AttackConfig) ([]string, error) {
+	if len(manifestHashes) == 0 {
+		return nil, nil
+	}
+	requests := generateVegetaRequests(DeleteIndexReportsRequests(ctx, manifestHashes, conf.Host, ""))
+	targeter := vegeta.NewStaticTargeter(requests...)
+	if conf.Auth != nil {
+		targeter = authTargeter(targeter, conf.Auth.Token)
+	}
+	plan := Plan{Rate: conf.Concurrency, Hits: uint64(len(requests))}
+	results, err := attack(ctx, targeter, plan, conf.Transport, "cleanup")
+	if err != nil {
+		return manifestHashes, err
+	}
+	deleted := make(map[string]bool, len(manifestHashes))
+	for res := range results {
+		if reportDeleted(res) {
+			deleted[manifestHashOf(res)] = true
+		}
+	}
+	return notDeleted(manifestHashes, deleted), nil
+}
+
+// RunDeletions runs the index report deletion phase like RunVegeta, following which index reports
+// it deleted, so that only the others need deleting again.
+// It returns the hashes whose index report is not known to be deleted, failed or never
+// requested when the phase was cut short, and an error if any during the execution.
+func RunDeletions(ctx context.Context, requestDicts []map[string]interface{}, manifestHashes []string, testName string, conf *AttackConfig) ([]string, error) {
+	deleted := make(map[string]bool, len(manifestHashes))
+	err := runVegeta(ctx, requestDicts, testName, conf, func(res *vegeta.Result) {
+		if reportDeleted(res) {
+			deleted[manifestHashOf(res)] = true
+		}
+	})
+	return notDeleted(manifestHashes, deleted), err
+}
+
+// reportDeleted reports whether the result of a DELETE leaves clair without the index report,
+// one it no longer has counting as deleted.
+func reportDeleted(res *vegeta.Result) bool {
+	return (res.Code >= 200 && res.Code < 300) || res.Code == http.StatusNotFound
+}
+
+// notDeleted returns the hashes missing from deleted, in order.
+func notDeleted(manifestHashes []string, deleted map[string]bool) []string {
+	var left []string
+	for _, h := range manifestHashes {
+		if !deleted[h] {
+			left = append(left, h)
+		}
+	}
+	return left
+}
@@ -0,0 +1,45 @@
+package attacker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunDeletions(t *testing.T) {
+	ctx := context.Background()
+	clair := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "sha256:2"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "sha256:3"):
+			http.NotFound(w, r)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer clair.Close()
+
+	hashes := []string{"sha256:1", "sha256:2", "sha256:3", "sha256:4"}
+	// The phase is cut short before the last index report
+	requests := DeleteIndexReportsRequests(ctx, hashes[:3], clair.URL, "")
+	conf := &AttackConfig{Concurrency: 50, Host: clair.URL}
+	left, err := RunDeletions(ctx, requests, hashes, "delete_index_report", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sha256:2", "sha256:4"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("got %v left, want %v", left, want)
+	}
+
+	left, err = Cleanup(ctx, left, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sha256:2"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("got %v left after the cleanup, want %v", left, want)
+	}
+}
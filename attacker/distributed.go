@@ -0,0 +1,366 @@
+package attacker
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quay/clair-load-test/auth"
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+	"golang.org/x/sync/errgroup"
+)
+
+// Constants
+const (
+	workerStartDelay   = 3 * time.Second
+	workerAttackPath   = "/attack"
+	workerHealthPath   = "/healthz"
+	workerCheckTimeout = 10 * time.Second
+	// WorkerSecretHeader carries the secret shared by the coordinator and its workers
+	WorkerSecretHeader = "X-Clair-Load-Test-Secret"
+	// workerInjectedTrailer carries the broken tokens a worker handed out, once its results are sent
+	workerInjectedTrailer = "X-Clair-Load-Test-Injected"
+)
+
+// Type used to serve attacks on behalf of a coordinator, one at a time.
+type worker struct {
+	mu     sync.Mutex
+	secret string
+}
+
+// NewWorker creates the HTTP handler of a worker process, only serving the callers
+// presenting the shared secret.
+// It returns the handler serving the health and attack endpoints.
+func NewWorker(secret string) http.Handler {
+	w := &worker{secret: secret}
+	mux := http.NewServeMux()
+	mux.HandleFunc(workerHealthPath, func(rw http.ResponseWriter, r *http.Request) {
+		if !w.authorized(r) {
+			http.Error(rw, "missing or wrong worker secret", http.StatusUnauthorized)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc(workerAttackPath, w.handleAttack)
+	return mux
+}
+
+// authorized reports whether the request presents the shared secret.
+// It returns a boolean, always false when the worker has no secret.
+func (w *worker) authorized(r *http.Request) bool {
+	got := r.Header.Get(WorkerSecretHeader)
+	return w.secret != "" && subtle.ConstantTimeCompare([]byte(got), []byte(w.secret)) == 1
+}
+
+// handleAttack runs the requested share of a phase and streams the raw results
+// back, gob encoded, as they arrive.
+func (w *worker) handleAttack(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !w.authorized(r) {
+		http.Error(rw, "missing or wrong worker secret", http.StatusUnauthorized)
+		return
+	}
+	if !w.mu.TryLock() {
+		http.Error(rw, "worker is busy with another attack", http.StatusConflict)
+		return
+	}
+	defer w.mu.Unlock()
+
+	var job WorkerJob
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(rw, fmt.Sprintf("could not decode job: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(job.Targets) == 0 || job.Plan.Rate <= 0 {
+		http.Error(rw, "job must have targets and a positive rate", http.StatusBadRequest)
+		return
+	}
+	zlog.Info(ctx).
+		Str("RUNID", job.RunID).
+		Str("phase", job.Phase).
+		Int("targets", len(job.Targets)).
+		Int("rate", job.Plan.Rate).
+		Uint64("hits", job.Plan.Hits).
+		Time("start_at", job.StartAt).
+		Msg("Received job")
+
+	// Every worker starts the phase at the same wall-clock time
+	select {
+	case <-time.After(time.Until(job.StartAt)):
+	case <-ctx.Done():
+		return
+	}
+
+	// Tokens are minted here, so that they are fresh however long the phase lasts
+	targeter := vegeta.NewStaticTargeter(job.Targets...)
+	var iss *auth.Issuer
+	if job.Auth != nil {
+		authConf := *job.Auth
+		authConf.PSK = job.PSK
+		var err error
+		if iss, err = auth.NewIssuer(authConf); err != nil {
+			http.Error(rw, fmt.Sprintf("could not set up authentication: %v", err), http.StatusBadRequest)
+			return
+		}
+		targeter = authTargeter(targeter, iss.Next)
+	}
+	results, err := attack(ctx, targeter, job.Plan, job.Transport, job.Phase)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Trailer", workerInjectedTrailer)
+	rw.WriteHeader(http.StatusOK)
+	enc := vegeta.NewEncoder(rw)
+	flusher, _ := rw.(http.Flusher)
+	var sent int
+	for res := range results {
+		if !job.KeepBodies {
+			res.Body = nil
+		}
+		if err := enc.Encode(res); err != nil {
+			zlog.Warn(ctx).Err(err).Str("phase", job.Phase).Msg("could not send result to the coordinator")
+			continue
+		}
+		// Send every result right away rather than once the buffer fills up
+		if flusher != nil {
+			flusher.Flush()
+		}
+		sent++
+	}
+	if iss != nil {
+		if b, err := json.Marshal(iss.Injected()); err == nil {
+			rw.Header().Set(workerInjectedTrailer, string(b))
+		}
+	}
+	zlog.Info(ctx).Str("RUNID", job.RunID).Str("phase", job.Phase).Int("results", sent).Msg("Finished job")
+}
+
+// CheckWorkers makes sure every worker is reachable and accepts the secret before the run starts.
+// It returns an error if any of the workers is not healthy.
+func CheckWorkers(ctx context.Context, workers []string, secret string) error {
+	client := &http.Client{Timeout: workerCheckTimeout}
+	for _, w := range workers {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(w, "/")+workerHealthPath, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(WorkerSecretHeader, secret)
+		res, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("worker %s is not reachable: %w", w, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("worker %s is not healthy: %s", w, res.Status)
+		}
+	}
+	zlog.Info(ctx).Int("workers", len(workers)).Msg("All workers are healthy")
+	return nil
+}
+
+// CheckWorkerPSK makes sure the PSK, when there is one to ship to the workers, cannot be read off the network:
+// every worker must be served over TLS or listen on the coordinator's host.
+// It returns an error naming the first worker the PSK would reach in clear text.
+func CheckWorkerPSK(workers []string, psk string) error {
+	if psk == "" {
+		return nil
+	}
+	for _, w := range workers {
+		if !privateTransport(w) {
+			return fmt.Errorf("Refusing to send the PSK to worker %s over plain HTTP. Serve the worker over TLS with --tls-cert and --tls-key and use an https address, or run it on the coordinator's host", w)
+		}
+	}
+	return nil
+}
+
+// privateTransport reports whether what is sent to the worker stays out of reach of the network.
+// It returns true for https addresses and for loopback ones.
+func privateTransport(addr string) bool {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// warnLocal logs that a phase which cannot be spread across the workers, such as one whose
+// requests depend on the responses to earlier ones, runs on the coordinator alone.
+func warnLocal(ctx context.Context, name string, conf *AttackConfig) {
+	if len(conf.Workers) == 0 {
+		return
+	}
+	zlog.Warn(ctx).Str("phase", name).Int("workers", len(conf.Workers)).Msg("Phase runs on the coordinator, the workers are not used")
+}
+
+// splitWork spreads the targets, the rate and the hits of a phase across the workers.
+// Arrivals of a trace are dealt out in turn, so that the workers together follow the trace.
+// Workers that would get no target, no rate or no hits at all are left out.
+// It returns one job per participating worker, indexed like the workers.
+func splitWork(targets []vegeta.Target, plan Plan, workers int) []WorkerJob {
+	if plan.Rate <= 0 || workers <= 0 || len(targets) == 0 {
+		return nil
+	}
+	if len(targets) < workers {
+		workers = len(targets)
+	}
+	if plan.Rate < workers {
+		workers = plan.Rate
+	}
+	if plan.Hits > 0 && plan.Hits < uint64(workers) {
+		workers = int(plan.Hits)
+	}
+	jobs := make([]WorkerJob, workers)
+	for i := range jobs {
+		jobs[i].Plan = plan
+		jobs[i].Plan.Rate = plan.Rate / workers
+		if i < plan.Rate%workers {
+			jobs[i].Plan.Rate++
+		}
+		if plan.Hits > 0 {
+			jobs[i].Plan.Hits = plan.Hits / uint64(workers)
+			if uint64(i) < plan.Hits%uint64(workers) {
+				jobs[i].Plan.Hits++
+			}
+		}
+	}
+	for i, t := range targets {
+		jobs[i%workers].Targets = append(jobs[i%workers].Targets, t)
+	}
+	if plan.Arrivals == ArrivalsTrace {
+		trace := plan.Trace
+		if plan.Hits > 0 && plan.Hits < uint64(len(trace)) {
+			trace = trace[:plan.Hits]
+		}
+		for i := range jobs {
+			jobs[i].Plan.Trace = nil
+		}
+		for i, offset := range trace {
+			jobs[i%workers].Plan.Trace = append(jobs[i%workers].Plan.Trace, offset)
+		}
+		for i := range jobs {
+			jobs[i].Plan.Hits = uint64(len(jobs[i].Plan.Trace))
+		}
+	}
+	return jobs
+}
+
+// runDistributed hands a share of the phase to every worker, with a common start time,
+// and merges the results they stream back.
+// It returns the merged results channel and a function reporting the first worker failure once it is drained.
+func runDistributed(ctx context.Context, targets []vegeta.Target, plan Plan, name string, conf *AttackConfig) (<-chan *vegeta.Result, func() error) {
+	startAt := time.Now().Add(workerStartDelay)
+	results := make(chan *vegeta.Result)
+	errc := make(chan error, 1)
+	jobs := splitWork(targets, plan, len(conf.Workers))
+	zlog.Info(ctx).Str("phase", name).Int("workers", len(jobs)).Time("start_at", startAt).Msg("Dispatching phase to workers")
+
+	var seq uint64
+	g, gctx := errgroup.WithContext(ctx)
+	for i := range jobs {
+		job := jobs[i]
+		job.RunID = conf.RUNID
+		job.Phase = name
+		job.StartAt = startAt
+		job.Transport = conf.Transport
+		job.KeepBodies = conf.Samples != nil
+		if conf.Auth != nil {
+			authConf := conf.Auth.Config()
+			job.Auth, job.PSK = &authConf, authConf.PSK
+		}
+		addr := conf.Workers[i]
+		g.Go(func() error {
+			// The PSK is a long-lived credential for clair, unlike the tokens minted from it
+			if err := CheckWorkerPSK([]string{addr}, job.PSK); err != nil {
+				return err
+			}
+			injected, err := dispatch(gctx, addr, conf.WorkerSecret, &job, func(res *vegeta.Result) error {
+				res.Seq = atomic.AddUint64(&seq, 1) - 1
+				select {
+				case results <- res:
+					return nil
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+			})
+			if conf.Auth != nil {
+				conf.Auth.AddInjected(injected)
+			}
+			return err
+		})
+	}
+	go func() {
+		errc <- g.Wait()
+		close(results)
+	}()
+	return results, func() error { return <-errc }
+}
+
+// dispatch sends a job to a single worker, along with the shared secret, and decodes the results it streams back.
+// It returns the broken tokens the worker handed out, and an error if the worker rejected the job or the stream broke.
+func dispatch(ctx context.Context, addr, secret string, job *WorkerJob, deliver func(*vegeta.Result) error) (map[string]uint64, error) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(addr, "/")+workerAttackPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WorkerSecretHeader, secret)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("worker %s: %w", addr, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("worker %s rejected the job: %s: %s", addr, res.Status, strings.TrimSpace(string(msg)))
+	}
+	dec := vegeta.NewDecoder(res.Body)
+	for {
+		var r vegeta.Result
+		if err := dec.Decode(&r); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("worker %s: could not decode results: %w", addr, err)
+			}
+			break
+		}
+		if err := deliver(&r); err != nil {
+			return nil, err
+		}
+	}
+	// Trailers are only there once the body is read through
+	var injected map[string]uint64
+	if t := res.Trailer.Get(workerInjectedTrailer); t != "" {
+		if err := json.Unmarshal([]byte(t), &injected); err != nil {
+			zlog.Warn(ctx).Err(err).Str("worker", addr).Msg("could not read the broken tokens the worker handed out")
+		}
+	}
+	return injected, nil
+}
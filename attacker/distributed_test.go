@@ -0,0 +1,260 @@
+package attacker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quay/clair-load-test/auth"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// captureIndexer keeps the documents of the phases instead of indexing them.
+type captureIndexer struct {
+	mu   sync.Mutex
+	docs []Document
+}
+
+func (c *captureIndexer) Index(ctx context.Context, documents []interface{}, name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range documents {
+		c.docs = append(c.docs, d.(Document))
+	}
+	return fmt.Sprintf("captured %d documents", len(documents)), nil
+}
+
+// countingServer answers 200 on /ok and 404 anywhere else, counting the requests it received.
+func countingServer(t *testing.T) (*httptest.Server, *uint64) {
+	var hits uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&hits, 1)
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// startWorkers starts n workers sharing the secret.
+// It returns their addresses.
+func startWorkers(t *testing.T, n int, secret string) []string {
+	var addrs []string
+	for i := 0; i < n; i++ {
+		srv := httptest.NewServer(NewWorker(secret))
+		t.Cleanup(srv.Close)
+		addrs = append(addrs, srv.URL)
+	}
+	return addrs
+}
+
+func TestRunDistributed(t *testing.T) {
+	ctx := context.Background()
+	clair, hits := countingServer(t)
+	workers := startWorkers(t, 3, "s3cret")
+	if err := CheckWorkers(ctx, workers, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []map[string]interface{}
+	for i := 0; i < 30; i++ {
+		path := "/ok"
+		if i%3 == 0 {
+			path = "/missing"
+		}
+		requests = append(requests, map[string]interface{}{
+			"method": http.MethodGet,
+			"url":    clair.URL + path,
+			"header": http.Header{},
+		})
+	}
+	idx := &captureIndexer{}
+	conf := &AttackConfig{
+		RUNID:        "run",
+		Concurrency:  100,
+		Host:         clair.URL,
+		Indexer:      idx,
+		Workers:      workers,
+		WorkerSecret: "s3cret",
+	}
+	if err := RunVegeta(ctx, requests, "distributed", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(idx.docs) != 1 {
+		t.Fatalf("got %d documents, want one for the whole phase", len(idx.docs))
+	}
+	doc := idx.docs[0]
+	if doc.Requests != 30 || doc.PlannedRequests != 30 {
+		t.Errorf("got %d requests out of %d planned, want 30", doc.Requests, doc.PlannedRequests)
+	}
+	if want := map[string]int{"200": 20, "404": 10}; !reflect.DeepEqual(doc.StatusCodes, want) {
+		t.Errorf("got status codes %v, want %v", doc.StatusCodes, want)
+	}
+	if got := atomic.LoadUint64(hits); got != 30 {
+		t.Errorf("clair received %d requests, want 30", got)
+	}
+}
+
+func TestSplitWork(t *testing.T) {
+	targets := make([]vegeta.Target, 2)
+	trace := make([]time.Duration, 9)
+	for i := range trace {
+		trace[i] = time.Duration(i) * time.Millisecond
+	}
+	for _, plan := range []Plan{
+		{Rate: 10, Duration: time.Second},
+		{Rate: 10, Hits: 7},
+		{Rate: 10, Arrivals: ArrivalsTrace, Trace: trace},
+	} {
+		// More workers than targets leaves the extra ones out rather than their share of the phase
+		jobs := splitWork(targets, plan, 3)
+		if len(jobs) != 2 {
+			t.Fatalf("%+v: got %d jobs, want one per target", plan, len(jobs))
+		}
+		var rate int
+		var hits uint64
+		var arrivals int
+		for _, j := range jobs {
+			if len(j.Targets) == 0 {
+				t.Errorf("%+v: got a job without targets", plan)
+			}
+			rate += j.Plan.Rate
+			hits += j.Plan.Hits
+			arrivals += len(j.Plan.Trace)
+		}
+		if rate != plan.Rate {
+			t.Errorf("%+v: got a rate of %d across the jobs, want %d", plan, rate, plan.Rate)
+		}
+		if plan.Hits > 0 && hits != plan.Hits {
+			t.Errorf("%+v: got %d hits across the jobs, want %d", plan, hits, plan.Hits)
+		}
+		if arrivals != len(plan.Trace) {
+			t.Errorf("%+v: got %d arrivals across the jobs, want %d", plan, arrivals, len(plan.Trace))
+		}
+	}
+}
+
+func TestRunDistributedAuth(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	tokens := map[string]int{}
+	clair := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tokens[r.Header.Get("Authorization")]++
+	}))
+	defer clair.Close()
+	workers := startWorkers(t, 2, "s3cret")
+
+	iss, err := auth.NewIssuer(auth.Config{
+		Mode:   auth.ModePSK,
+		PSK:    "RUZMTEVxMFI2QmVTRnhhNG5VUTF0ZVJZb1hLeTYwY20=",
+		Scope:  auth.ScopeRequest,
+		Faults: auth.Faults{Missing: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests []map[string]interface{}
+	for i := 0; i < 40; i++ {
+		requests = append(requests, map[string]interface{}{
+			"method": http.MethodGet,
+			"url":    clair.URL + "/ok",
+			"header": http.Header{"Authorization": []string{"Bearer stale"}},
+		})
+	}
+	idx := &captureIndexer{}
+	conf := &AttackConfig{Concurrency: 100, Indexer: idx, Workers: workers, WorkerSecret: "s3cret", Auth: iss}
+	if err := RunVegeta(ctx, requests, "distributed-auth", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Workers mint a token per request and leave out the configured share of them
+	if tokens["Bearer stale"] != 0 {
+		t.Errorf("%d requests kept the token of the targets", tokens["Bearer stale"])
+	}
+	missing := tokens[""]
+	for tok, n := range tokens {
+		if tok != "" && n != 1 {
+			t.Errorf("token sent %d times, want one token per request", n)
+		}
+	}
+	if len(idx.docs) != 1 || idx.docs[0].Auth == nil {
+		t.Fatalf("got %+v, want one document with its auth summary", idx.docs)
+	}
+	if got := idx.docs[0].Auth.Injected[auth.FaultMissing]; got != uint64(missing) {
+		t.Errorf("got %d missing tokens injected, clair saw %d", got, missing)
+	}
+	if missing == 0 || missing == 40 {
+		t.Errorf("clair saw %d requests without a token, want about half", missing)
+	}
+}
+
+func TestWorkerSecret(t *testing.T) {
+	ctx := context.Background()
+	clair, hits := countingServer(t)
+	workers := startWorkers(t, 1, "s3cret")
+	for _, secret := range []string{"", "wrong"} {
+		if err := CheckWorkers(ctx, workers, secret); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("secret %q: got %v, want the worker to be unhealthy", secret, err)
+		}
+		conf := &AttackConfig{Concurrency: 10, Workers: workers, WorkerSecret: secret}
+		requests := []map[string]interface{}{{"method": http.MethodGet, "url": clair.URL + "/ok", "header": http.Header{}}}
+		if err := RunVegeta(ctx, requests, "rejected", conf); err == nil || !strings.Contains(err.Error(), "rejected the job") {
+			t.Errorf("secret %q: got %v, want the job to be rejected", secret, err)
+		}
+	}
+	// A worker without a secret serves nobody
+	open := startWorkers(t, 1, "")
+	if err := CheckWorkers(ctx, open, ""); err == nil {
+		t.Error("a worker without a secret must not be healthy")
+	}
+	if got := atomic.LoadUint64(hits); got != 0 {
+		t.Errorf("clair received %d requests, want none", got)
+	}
+}
+
+func TestWorkerPSK(t *testing.T) {
+	for _, tc := range []struct {
+		worker string
+		psk    string
+		ok     bool
+	}{
+		{"http://worker-1:8080", "", true},
+		{"http://worker-1:8080", mockPSK, false},
+		{"http://10.0.0.1:8080", mockPSK, false},
+		{"https://worker-1:8080", mockPSK, true},
+		{"http://localhost:9001", mockPSK, true},
+		{"http://127.0.0.1:9001", mockPSK, true},
+		{"http://[::1]:9001", mockPSK, true},
+	} {
+		if err := CheckWorkerPSK([]string{tc.worker}, tc.psk); (err == nil) != tc.ok {
+			t.Errorf("worker %s with PSK %q: got %v, want ok %v", tc.worker, tc.psk, err, tc.ok)
+		}
+	}
+
+	// A job holding the PSK is not sent in clear text off the coordinator's host
+	ctx := context.Background()
+	clair, hits := countingServer(t)
+	iss, err := auth.NewIssuer(auth.Config{Mode: auth.ModePSK, PSK: mockPSK, Scope: auth.ScopeRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &AttackConfig{Concurrency: 10, Workers: []string{"http://worker.invalid:8080"}, WorkerSecret: "s3cret", Auth: iss}
+	requests := []map[string]interface{}{{"method": http.MethodGet, "url": clair.URL + "/ok", "header": http.Header{}}}
+	if err := RunVegeta(ctx, requests, "psk", conf); err == nil || !strings.Contains(err.Error(), "plain HTTP") {
+		t.Errorf("got %v, want the job to be refused", err)
+	}
+	if got := atomic.LoadUint64(hits); got != 0 {
+		t.Errorf("clair received %d requests, want none", got)
+	}
+}
@@ -0,0 +1,229 @@
+package attacker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/quay/clair-load-test/auth"
+	"github.com/quay/clair-load-test/mockclair"
+)
+
+// mockPSK is the key the mock clair servers of the tests expect tokens to be signed with.
+const mockPSK = "RUZMTEVxMFI2QmVTRnhhNG5VUTF0ZVJZb1hLeTYwY20="
+
+// startMockClair serves a mock clair with the configuration, reachable at the URL it returns.
+func startMockClair(t *testing.T, c mockclair.Config) (*mockclair.Server, string) {
+	var mock *mockclair.Server
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c.URL = srv.URL
+	var err error
+	if mock, err = mockclair.New(c); err != nil {
+		t.Fatal(err)
+	}
+	return mock, srv.URL
+}
+
+// mockManifests makes up n manifests without layers.
+// It returns the manifests and their hashes.
+func mockManifests(n int) ([][]byte, []string) {
+	var manifests [][]byte
+	var hashes []string
+	for i := 0; i < n; i++ {
+		hash := fmt.Sprintf("sha256:%064x", i+1)
+		manifests = append(manifests, []byte(fmt.Sprintf(`{"hash":%q,"layers":[]}`, hash)))
+		hashes = append(hashes, hash)
+	}
+	return manifests, hashes
+}
+
+// lastDocument returns the document of the last phase captured.
+func lastDocument(t *testing.T, idx *captureIndexer) Document {
+	t.Helper()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if len(idx.docs) == 0 {
+		t.Fatal("no document was indexed")
+	}
+	return idx.docs[len(idx.docs)-1]
+}
+
+func TestRunVegetaMockClair(t *testing.T) {
+	ctx := context.Background()
+	_, host := startMockClair(t, mockclair.Config{PSK: mockPSK})
+	manifests, hashes := mockManifests(5)
+	iss, err := auth.NewIssuer(auth.Config{Mode: auth.ModePSK, PSK: mockPSK, Scope: auth.ScopeRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &captureIndexer{}
+	conf := &AttackConfig{Concurrency: 50, Host: host, Indexer: idx, Auth: iss}
+
+	if err := RunVegeta(ctx, CreateIndexReportRequests(ctx, manifests, host, ""), "post_index_report", conf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastDocument(t, idx).StatusCodes, map[string]int{"201": 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got status codes %v posting the index reports, want %v", got, want)
+	}
+	if err := RunVegeta(ctx, GetVulnerabilityReportRequests(ctx, hashes, host, ""), "get_vulnerability_report", conf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastDocument(t, idx).StatusCodes, map[string]int{"200": 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got status codes %v getting the vulnerability reports, want %v", got, want)
+	}
+
+	// Without tokens every request is rejected
+	conf.Auth = nil
+	if err := RunVegeta(ctx, GetVulnerabilityReportRequests(ctx, hashes, host, ""), "unauthenticated", conf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastDocument(t, idx).StatusCodes, map[string]int{"401": 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got status codes %v without tokens, want %v", got, want)
+	}
+}
+
+func TestRunVegetaMockClairAuthFaults(t *testing.T) {
+	ctx := context.Background()
+	_, host := startMockClair(t, mockclair.Config{PSK: mockPSK})
+	iss, err := auth.NewIssuer(auth.Config{
+		Mode:   auth.ModePSK,
+		PSK:    mockPSK,
+		Scope:  auth.ScopeRequest,
+		Faults: auth.Faults{Missing: 0.3, BadSignature: 0.3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &captureIndexer{}
+	conf := &AttackConfig{Concurrency: 200, Host: host, Indexer: idx, Auth: iss}
+	if err := RunVegeta(ctx, GetIndexerStateRequests(ctx, 100, host, ""), "auth_faults", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every fault injected during the phase, and only those, is rejected
+	doc := lastDocument(t, idx)
+	if doc.Auth == nil {
+		t.Fatal("the document has no auth summary")
+	}
+	var injected uint64
+	for _, n := range doc.Auth.Injected {
+		injected += n
+	}
+	if injected == 0 || injected != doc.Auth.Rejected || uint64(doc.StatusCodes["401"]) != injected {
+		t.Errorf("got %d faults injected, %d rejected and status codes %v, want them to match", injected, doc.Auth.Rejected, doc.StatusCodes)
+	}
+}
+
+func TestRunSessionsMockClair(t *testing.T) {
+	ctx := context.Background()
+	_, host := startMockClair(t, mockclair.Config{IndexDelay: 50 * time.Millisecond})
+	manifests, hashes := mockManifests(4)
+	idx := &captureIndexer{}
+	conf := &AttackConfig{Concurrency: 50, Host: host, Indexer: idx, Duration: 30 * time.Second}
+	sconf := SessionConfig{Users: 2, PollInterval: 10 * time.Millisecond, PollTimeout: 5 * time.Second, Retries: 1, RetryBackoff: 10 * time.Millisecond}
+	if err := RunSessions(ctx, manifests, hashes, sconf, "sessions", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	s := lastDocument(t, idx).Session
+	if s == nil {
+		t.Fatal("the document has no session summary")
+	}
+	if s.Sessions != 4 || s.Completed != 4 || s.Failed != 0 {
+		t.Errorf("got %d sessions, %d completed and %d failed, want all 4 completed", s.Sessions, s.Completed, s.Failed)
+	}
+	// Indexing takes a while, so every session polls at least once
+	if s.Polls < 4 {
+		t.Errorf("got %d polls, want at least one per session", s.Polls)
+	}
+}
+
+func TestRunNotifierMockClair(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hook := NewWebhook()
+	webhook := httptest.NewServer(hook)
+	defer webhook.Close()
+	mock, host := startMockClair(t, mockclair.Config{
+		Webhook:              webhook.URL,
+		NotificationInterval: 20 * time.Millisecond,
+		NotificationSize:     10,
+	})
+	go mock.Notify(ctx)
+
+	idx := &captureIndexer{}
+	conf := &AttackConfig{Concurrency: 50, Host: host, Indexer: idx}
+	nconf := NotifierConfig{PageSize: 4, Notifications: 3, Duration: 30 * time.Second, Delete: true}
+	if err := RunNotifier(ctx, hook, nconf, "notifier", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	n := lastDocument(t, idx).Notifier
+	if n == nil {
+		t.Fatal("the document has no notifier summary")
+	}
+	if n.Completed < 3 || n.Failed != 0 {
+		t.Errorf("got %d notifications completed and %d failed, want at least 3 completed", n.Completed, n.Failed)
+	}
+	if n.Deleted != n.Completed {
+		t.Errorf("got %d notifications deleted, want the %d completed", n.Deleted, n.Completed)
+	}
+	// Ten entries four at a time take three pages
+	if n.Pages < 3*n.Completed || n.Notifications < 10*n.Completed {
+		t.Errorf("got %d pages and %d entries for %d notifications, want 3 pages of 10 entries each", n.Pages, n.Notifications, n.Completed)
+	}
+	if n.Callbacks < n.Completed {
+		t.Errorf("got %d callbacks, want at least one per notification", n.Callbacks)
+	}
+}
+
+func TestCleanupMockClair(t *testing.T) {
+	ctx := context.Background()
+	_, host := startMockClair(t, mockclair.Config{})
+	manifests, hashes := mockManifests(3)
+	idx := &captureIndexer{}
+	conf := &AttackConfig{Concurrency: 50, Host: host, Indexer: idx}
+	if err := RunVegeta(ctx, CreateIndexReportRequests(ctx, manifests, host, ""), "post_index_report", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The phase is cut short before the last index report
+	left, err := RunDeletions(ctx, DeleteIndexReportsRequests(ctx, hashes[:2], host, ""), hashes, "delete_index_report", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hashes[2:]; !reflect.DeepEqual(left, want) {
+		t.Errorf("got %v left, want %v", left, want)
+	}
+	if left, err = Cleanup(ctx, left, conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("got %v left after the cleanup, want none", left)
+	}
+	if err := RunVegeta(ctx, GetIndexReportRequests(ctx, hashes, host, ""), "get_index_report", conf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastDocument(t, idx).StatusCodes, map[string]int{"404": 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got status codes %v after the cleanup, want %v", got, want)
+	}
+
+	// Index reports clair fails to delete are left to the next cleanup
+	_, failing := startMockClair(t, mockclair.Config{Routes: map[string]mockclair.Behavior{
+		mockclair.RouteIndexReport: {ErrorRate: 1, ErrorCode: http.StatusServiceUnavailable},
+	}})
+	left, err = Cleanup(ctx, hashes, &AttackConfig{Concurrency: 50, Host: failing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(left, hashes) {
+		t.Errorf("got %v left when clair fails, want %v", left, hashes)
+	}
+}
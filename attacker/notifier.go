@@ -0,0 +1,378 @@
+package attacker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Constants
+const (
+	notificationPath  = "/notifier/api/v1/notification/"
+	notifierQueueSize = 10000
+	lastPage          = "-1"
+	firstPageRetries  = 3
+	// firstPageBackoff is how long before the first page is asked for again, doubling every time
+	firstPageBackoff = 500 * time.Millisecond
+)
+
+// Type used to receive the callbacks clair's notifier delivers to its webhook.
+type Webhook struct {
+	callbacks chan Callback
+	received  uint64
+	dropped   uint64
+}
+
+// NewWebhook creates a webhook receiver queueing the callbacks for the notifier workload.
+// It returns the webhook, to be served on the address clair delivers to.
+func NewWebhook() *Webhook {
+	return &Webhook{callbacks: make(chan Callback, notifierQueueSize)}
+}
+
+// ServeHTTP records a callback along with the time it was received.
+// Callbacks arriving while the queue is full are answered but dropped.
+func (h *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var cb Callback
+	if err := json.NewDecoder(r.Body).Decode(&cb); err != nil || cb.NotificationID == "" {
+		http.Error(w, "invalid callback", http.StatusBadRequest)
+		return
+	}
+	cb.Received = time.Now()
+	atomic.AddUint64(&h.received, 1)
+	select {
+	case h.callbacks <- cb:
+	default:
+		atomic.AddUint64(&h.dropped, 1)
+		zlog.Warn(r.Context()).Str("notification_id", cb.NotificationID).Msg("webhook queue full, dropping callback")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Received returns how many callbacks were received and how many of them were dropped so far.
+func (h *Webhook) Received() (received, dropped uint64) {
+	return atomic.LoadUint64(&h.received), atomic.LoadUint64(&h.dropped)
+}
+
+// Type used to follow a notification from its callback until it is retrieved and deleted.
+type notification struct {
+	received    time.Time
+	retrievable bool
+	attempts    int
+}
+
+// Type used to describe a single request of the notifier workload, not to be sent before due.
+type notifierTask struct {
+	method string
+	id     string
+	next   string
+	due    time.Time
+}
+
+// Type used to drive the notifier workload: it paces the requests as callbacks arrive
+// and follow up requests are due, and keeps track of every notification in flight.
+type notifierRun struct {
+	ctx      context.Context
+	hook     *Webhook
+	nconf    NotifierConfig
+	host     string
+	rate     int
+	deadline <-chan time.Time
+	ready    chan notifierTask
+	wake     chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+	last     time.Time
+
+	mu        sync.Mutex
+	queue     []notifierTask
+	pending   map[string]*notification
+	summary   NotifierSummary
+	latencies []time.Duration
+}
+
+// Pace blocks until a request is due, follow ups first, then hands it to the targeter
+// and spaces the requests out to the configured rate.
+// It stops the attack once the deadline passed, enough notifications were completed or ctx is done.
+func (r *notifierRun) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	for {
+		task, wait, ok := r.pop()
+		if ok {
+			return r.dispatch(task), false
+		}
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+		var tracked, stop bool
+		select {
+		case cb := <-r.hook.callbacks:
+			task, tracked = r.track(cb)
+		case <-fire:
+		case <-r.wake:
+		case <-r.done:
+			stop = true
+		case <-r.deadline:
+			stop = true
+		case <-r.ctx.Done():
+			stop = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		switch {
+		case stop:
+			return 0, true
+		case tracked:
+			return r.dispatch(task), false
+		}
+	}
+}
+
+// Rate returns the configured rate.
+func (r *notifierRun) Rate(elapsed time.Duration) float64 {
+	return float64(r.rate)
+}
+
+// dispatch hands a task to the targeter.
+// It returns how long to wait before sending it to keep to the rate.
+func (r *notifierRun) dispatch(task notifierTask) time.Duration {
+	r.ready <- task
+	now := time.Now()
+	at := r.last.Add(time.Second / time.Duration(r.rate))
+	if at.Before(now) {
+		at = now
+	}
+	r.last = at
+	return at.Sub(now)
+}
+
+// targeter builds the request of the task handed out by the pacer.
+// It returns an error if the token could not be issued.
+func (r *notifierRun) targeter(conf *AttackConfig) vegeta.Targeter {
+	return func(tgt *vegeta.Target) error {
+		task := <-r.ready
+		u := r.host + notificationPath + task.id
+		if task.method == http.MethodGet {
+			q := url.Values{}
+			q.Set("page_size", strconv.Itoa(r.nconf.PageSize))
+			if task.next != "" {
+				q.Set("next", task.next)
+			}
+			u += "?" + q.Encode()
+		}
+		tgt.Method = task.method
+		tgt.URL = u
+		tgt.Header = http.Header{"Content-Type": []string{"application/json"}}
+		if conf.Auth != nil {
+			tok, err := conf.Auth.Next()
+			if err != nil {
+				return err
+			}
+			tgt.Header = withToken(tgt.Header, tok)
+		}
+		return nil
+	}
+}
+
+// track starts following the notification of a callback, ignoring redeliveries.
+// It returns the request of its first page and whether there is one.
+func (r *notifierRun) track(cb Callback) (notifierTask, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pending[cb.NotificationID]; ok {
+		return notifierTask{}, false
+	}
+	r.pending[cb.NotificationID] = &notification{received: cb.Received}
+	return notifierTask{method: http.MethodGet, id: cb.NotificationID}, true
+}
+
+// pop takes the oldest follow up request that is due.
+// It returns the request and true, or how long until the earliest one is due and false,
+// zero when none is queued.
+func (r *notifierRun) pop() (notifierTask, time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for i, task := range r.queue {
+		if !task.due.After(now) {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			return task, 0, true
+		}
+		if d := task.due.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return notifierTask{}, wait, false
+}
+
+// push queues a follow up request and wakes the pacer up. The lock must be held.
+func (r *notifierRun) push(task notifierTask) {
+	r.queue = append(r.queue, task)
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// observe follows a notification up with its next page, its deletion or its completion.
+func (r *notifierRun) observe(res *vegeta.Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		return
+	}
+	id := path.Base(u.Path)
+	next := u.Query().Get("next")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.pending[id]
+	if !ok {
+		return
+	}
+	if res.Method == http.MethodDelete {
+		if res.Code >= 200 && res.Code < 300 {
+			r.summary.Deleted++
+			r.complete(id)
+		} else {
+			r.fail(id)
+		}
+		return
+	}
+	if res.Code != http.StatusOK {
+		// The first page may not be retrievable yet right after the callback
+		if next == "" && !n.retrievable && n.attempts < firstPageRetries {
+			r.summary.Retries++
+			r.push(notifierTask{method: http.MethodGet, id: id, due: time.Now().Add(firstPageBackoff << n.attempts)})
+			n.attempts++
+			return
+		}
+		r.fail(id)
+		return
+	}
+	var page struct {
+		Page struct {
+			Next *string `json:"next"`
+		} `json:"page"`
+		Notifications []json.RawMessage `json:"notifications"`
+	}
+	if err := json.Unmarshal(res.Body, &page); err != nil {
+		r.fail(id)
+		return
+	}
+	r.summary.Pages++
+	r.summary.Notifications += uint64(len(page.Notifications))
+	if !n.retrievable {
+		n.retrievable = true
+		r.latencies = append(r.latencies, res.Timestamp.Add(res.Latency).Sub(n.received))
+	}
+	switch {
+	case page.Page.Next != nil && *page.Page.Next != "" && *page.Page.Next != lastPage:
+		r.push(notifierTask{method: http.MethodGet, id: id, next: *page.Page.Next})
+	case r.nconf.Delete:
+		r.push(notifierTask{method: http.MethodDelete, id: id})
+	default:
+		r.complete(id)
+	}
+}
+
+// complete marks a notification as fully handled and stops the attack once enough were. The lock must be held.
+func (r *notifierRun) complete(id string) {
+	delete(r.pending, id)
+	r.summary.Completed++
+	if r.nconf.Notifications > 0 && r.summary.Completed >= uint64(r.nconf.Notifications) {
+		r.doneOnce.Do(func() { close(r.done) })
+	}
+}
+
+// fail gives up on a notification. The lock must be held.
+func (r *notifierRun) fail(id string) {
+	delete(r.pending, id)
+	r.summary.Failed++
+}
+
+// decorate adds the notifier summary to the document of the phase.
+func (r *notifierRun) decorate(doc *Document) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.summary
+	s.Callbacks, s.CallbacksDropped = r.hook.Received()
+	s.InFlight = uint64(len(r.pending))
+	s.CallbackToRetrievable = newDurationSummary(r.latencies)
+	doc.Notifier = &s
+}
+
+// RunNotifier runs the notifier workload: every notification clair calls the webhook back for
+// is paged through and, when configured, deleted, at the configured rate.
+// It stops after the configured duration or number of notifications, or when ctx is done.
+// It returns an error if any during the execution.
+func RunNotifier(ctx context.Context, hook *Webhook, nconf NotifierConfig, testName string, conf *AttackConfig) error {
+	warnLocal(ctx, testName, conf)
+	r := &notifierRun{
+		ctx:     ctx,
+		hook:    hook,
+		nconf:   nconf,
+		host:    conf.Host,
+		rate:    conf.Concurrency,
+		ready:   make(chan notifierTask, notifierQueueSize),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: map[string]*notification{},
+	}
+	if nconf.Duration > 0 {
+		timer := time.NewTimer(nconf.Duration)
+		defer timer.Stop()
+		r.deadline = timer.C
+	}
+	return runPhase(ctx, phase{
+		name: testName,
+		plan: Plan{Rate: conf.Concurrency, Duration: nconf.Duration},
+		start: func(ctx context.Context) (<-chan *vegeta.Result, func() error, error) {
+			results, err := attackPaced(ctx, r.targeter(conf), r, nconf.Duration, conf.Transport, testName)
+			return results, func() error { return nil }, err
+		},
+		observe:  r.observe,
+		decorate: r.decorate,
+	}, conf)
+}
+
+// newDurationSummary summarises a list of durations.
+// It returns the latency summary, zero when the list is empty.
+func newDurationSummary(ds []time.Duration) LatencySummary {
+	if len(ds) == 0 {
+		return LatencySummary{}
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	at := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+	return LatencySummary{
+		Mean: total / time.Duration(len(sorted)),
+		P50:  at(0.50),
+		P95:  at(0.95),
+		P99:  at(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
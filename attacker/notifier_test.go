@@ -0,0 +1,61 @@
+package attacker
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunNotifierFirstPageBackoff(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var gets []time.Time
+	// The notification only becomes retrievable on the third try
+	clair := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			return
+		}
+		mu.Lock()
+		gets = append(gets, time.Now())
+		n := len(gets)
+		mu.Unlock()
+		if n < 3 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"page":{"size":10},"notifications":[{"id":"1"}]}`))
+	}))
+	defer clair.Close()
+	hook := NewWebhook()
+	webhook := httptest.NewServer(hook)
+	defer webhook.Close()
+	res, err := http.Post(webhook.URL, "application/json", bytes.NewBufferString(`{"notification_id":"n1","callback":"`+clair.URL+notificationPath+`n1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	idx := &captureIndexer{}
+	conf := &AttackConfig{Concurrency: 100, Host: clair.URL, Indexer: idx}
+	nconf := NotifierConfig{PageSize: 10, Notifications: 1, Duration: 30 * time.Second, Delete: true}
+	if err := RunNotifier(ctx, hook, nconf, "notifier", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	n := lastDocument(t, idx).Notifier
+	if n == nil || n.Completed != 1 || n.Retries != 2 {
+		t.Fatalf("got %+v, want the notification completed after 2 retries", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// Retries back off rather than being sent right away
+	for i, want := range []time.Duration{firstPageBackoff, 2 * firstPageBackoff} {
+		if got := gets[i+1].Sub(gets[i]); got < want {
+			t.Errorf("retry %d sent %v after the previous try, want at least %v", i+1, got, want)
+		}
+	}
+}
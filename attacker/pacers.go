@@ -0,0 +1,135 @@
+package attacker
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// newPacer creates the pacer spacing the arrivals of a plan.
+// It returns a constant rate pacer unless Poisson or trace driven arrivals were asked for.
+func newPacer(plan Plan) vegeta.Pacer {
+	switch plan.Arrivals {
+	case ArrivalsPoisson:
+		return &poissonPacer{rate: float64(plan.Rate)}
+	case ArrivalsTrace:
+		return tracePacer{offsets: plan.Trace, speed: plan.Speed}
+	}
+	return vegeta.Rate{Freq: plan.Rate, Per: time.Second}
+}
+
+// Type used to space arrivals as a Poisson process of a mean rate, with exponentially
+// distributed gaps between them. Vegeta calls Pace from a single goroutine.
+type poissonPacer struct {
+	rate float64
+	// due is when the hit number next is due, since the start of the attack
+	due  time.Duration
+	next uint64
+}
+
+// Pace draws the arrival of the next hit and waits for it.
+func (p *poissonPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if p.rate <= 0 {
+		return 0, true
+	}
+	for p.next <= hits {
+		p.due += time.Duration(rand.ExpFloat64() / p.rate * float64(time.Second))
+		p.next++
+	}
+	if p.due > elapsed {
+		return p.due - elapsed, false
+	}
+	return 0, false
+}
+
+// Rate returns the mean rate of the process.
+func (p *poissonPacer) Rate(elapsed time.Duration) float64 {
+	return p.rate
+}
+
+// Type used to send hits at the offsets of a trace, divided by the speed.
+type tracePacer struct {
+	offsets []time.Duration
+	speed   float64
+}
+
+// due returns when the hit number i is due, since the start of the attack.
+func (p tracePacer) due(i int) time.Duration {
+	if p.speed <= 0 {
+		return p.offsets[i]
+	}
+	return time.Duration(float64(p.offsets[i]) / p.speed)
+}
+
+// Pace waits for the offset of the next hit and stops once the trace is over.
+func (p tracePacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if hits >= uint64(len(p.offsets)) {
+		return 0, true
+	}
+	if due := p.due(int(hits)); due > elapsed {
+		return due - elapsed, false
+	}
+	return 0, false
+}
+
+// Rate returns the average rate of the trace.
+func (p tracePacer) Rate(elapsed time.Duration) float64 {
+	if len(p.offsets) == 0 {
+		return 0
+	}
+	span := p.due(len(p.offsets) - 1)
+	if span <= 0 {
+		return 0
+	}
+	return float64(len(p.offsets)) / span.Seconds()
+}
+
+// ReadTrace loads arrival times, one per line, either RFC 3339 timestamps or unix times in
+// seconds, such as the ones of access logs. Empty lines and lines starting with # are skipped.
+// It returns the arrivals as offsets since the first one, in order, and an error if the file
+// could not be read or holds something else.
+func ReadTrace(path string) ([]time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open arrival trace: %w", err)
+	}
+	defer f.Close()
+	var arrivals []time.Time
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		v := strings.TrimSpace(scanner.Text())
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			secs, ferr := strconv.ParseFloat(v, 64)
+			if ferr != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+				return nil, fmt.Errorf("invalid arrival %q in %s at line %d: must be an RFC 3339 timestamp or unix seconds", v, path, line)
+			}
+			whole, frac := math.Modf(secs)
+			t = time.Unix(int64(whole), int64(frac*float64(time.Second)))
+		}
+		arrivals = append(arrivals, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read arrival trace: %w", err)
+	}
+	if len(arrivals) == 0 {
+		return nil, fmt.Errorf("no arrival in %s", path)
+	}
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i].Before(arrivals[j]) })
+	offsets := make([]time.Duration, len(arrivals))
+	for i, t := range arrivals {
+		offsets[i] = t.Sub(arrivals[0])
+	}
+	return offsets, nil
+}
@@ -0,0 +1,124 @@
+package attacker
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quay/clair-load-test/recording"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Type used to play a recording back, each request sent at its recorded offset divided by the speed.
+type replayRun struct {
+	tracePacer
+	entries []recording.Entry
+	host    string
+
+	mu        sync.Mutex
+	next      int
+	started   time.Time
+	summary   ReplaySummary
+	lags      []time.Duration
+	latencies []time.Duration
+}
+
+// targeter builds the recorded requests in order. The entry is kept in the URL fragment,
+// which is never sent, to tell the results apart.
+// It returns an error once every request was sent or if the token could not be issued.
+func (r *replayRun) targeter(conf *AttackConfig) vegeta.Targeter {
+	tr := func(tgt *vegeta.Target) error {
+		r.mu.Lock()
+		i := r.next
+		r.next++
+		r.mu.Unlock()
+		if i >= len(r.entries) {
+			return vegeta.ErrNoTargets
+		}
+		e := r.entries[i]
+		tgt.Method = e.Method
+		tgt.URL = r.host + e.Path + "#" + strconv.Itoa(i)
+		tgt.Header = e.Header.Clone()
+		tgt.Body = e.Body
+		return nil
+	}
+	if conf.Auth != nil {
+		return authTargeter(tr, conf.Auth.Next)
+	}
+	return tr
+}
+
+// observe compares a result with the recorded response and measures how late it was sent.
+func (r *replayRun) observe(res *vegeta.Result) {
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		return
+	}
+	i, err := strconv.Atoi(u.Fragment)
+	if err != nil || i < 0 || i >= len(r.entries) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if res.Code != uint16(r.entries[i].Status) {
+		r.summary.StatusMismatches++
+	}
+	lag := res.Timestamp.Sub(r.started.Add(r.due(i)))
+	if lag < 0 {
+		lag = 0
+	}
+	r.lags = append(r.lags, lag)
+}
+
+// decorate adds the replay summary to the document of the phase.
+func (r *replayRun) decorate(doc *Document) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.summary
+	s.RecordedLatency = newDurationSummary(r.latencies)
+	s.Lag = newDurationSummary(r.lags)
+	doc.Replay = &s
+	doc.TargetRate = int(r.Rate(0))
+}
+
+// RunReplay plays recorded requests back against clair, with their recorded inter-arrival
+// times divided by the configured speed, and compares the responses with the recorded ones.
+// It stops after the configured number of requests or duration, or when ctx is done.
+// It returns an error if any during the execution.
+func RunReplay(ctx context.Context, entries []recording.Entry, rconf ReplayConfig, testName string, conf *AttackConfig) error {
+	warnLocal(ctx, testName, conf)
+	if conf.Requests > 0 && uint64(len(entries)) > conf.Requests {
+		entries = entries[:conf.Requests]
+	}
+	if rconf.Speed <= 0 {
+		rconf.Speed = 1
+	}
+	r := &replayRun{
+		tracePacer: tracePacer{speed: rconf.Speed},
+		entries:    entries,
+		host:       conf.Host,
+		summary:    ReplaySummary{Recording: rconf.Recording, Speed: rconf.Speed, Recorded: uint64(len(entries))},
+	}
+	for _, e := range entries {
+		r.offsets = append(r.offsets, e.Offset)
+		r.latencies = append(r.latencies, e.Latency)
+	}
+	if len(entries) > 0 {
+		r.summary.Span = entries[len(entries)-1].Offset - entries[0].Offset
+	}
+	return runPhase(ctx, phase{
+		name: testName,
+		plan: Plan{Rate: int(r.Rate(0)), Hits: uint64(len(entries)), Duration: conf.Duration},
+		start: func(ctx context.Context) (<-chan *vegeta.Result, func() error, error) {
+			r.mu.Lock()
+			r.started = time.Now()
+			r.mu.Unlock()
+			results, err := attackPaced(ctx, r.targeter(conf), r, conf.Duration, conf.Transport, testName)
+			return results, func() error { return nil }, err
+		},
+		observe:  r.observe,
+		decorate: r.decorate,
+	}, conf)
+}
@@ -0,0 +1,162 @@
+package attacker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Constants
+const (
+	defaultSamplesBatchSize  = 1000
+	defaultSamplesBufferSize = 10000
+	samplesFlushInterval     = 5 * time.Second
+)
+
+// sampleSink batches per request samples and indexes them in the background.
+// Adding a sample never blocks: when the buffer is full the sample is dropped
+// so that a slow indexer cannot slow down the attack itself.
+type sampleSink struct {
+	ctx       context.Context
+	conf      *AttackConfig
+	phase     string
+	samples   chan *vegeta.Result
+	wg        sync.WaitGroup
+	batchSize int
+	batches   int
+	dropped   uint64
+	indexed   uint64
+	failed    uint64
+}
+
+// newSampleSink starts the background indexing of samples for a phase.
+// It returns the sink, which must be closed once the attack is over.
+func newSampleSink(ctx context.Context, conf *AttackConfig, phase string) *sampleSink {
+	batchSize := conf.SamplesBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultSamplesBatchSize
+	}
+	bufferSize := conf.SamplesBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultSamplesBufferSize
+	}
+	s := &sampleSink{
+		ctx:       ctx,
+		conf:      conf,
+		phase:     phase,
+		samples:   make(chan *vegeta.Result, bufferSize),
+		batchSize: batchSize,
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Add queues a result for indexing, dropping it if the buffer is full.
+func (s *sampleSink) Add(res *vegeta.Result) {
+	select {
+	case s.samples <- res:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Close flushes the queued samples and waits for the background indexing to finish.
+func (s *sampleSink) Close() {
+	close(s.samples)
+	s.wg.Wait()
+	zlog.Info(s.ctx).
+		Str("phase", s.phase).
+		Uint64("indexed", s.indexed).
+		Uint64("failed", s.failed).
+		Uint64("dropped", s.dropped).
+		Msg("Finished indexing samples")
+}
+
+// run drains the queue and flushes full batches or, periodically, partial ones.
+func (s *sampleSink) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(samplesFlushInterval)
+	defer ticker.Stop()
+	batch := make([]interface{}, 0, s.batchSize)
+	for {
+		select {
+		case res, ok := <-s.samples:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			batch = append(batch, s.newSample(res))
+			if len(batch) >= s.batchSize {
+				s.flush(batch)
+				batch = make([]interface{}, 0, s.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.flush(batch)
+				batch = make([]interface{}, 0, s.batchSize)
+			}
+		}
+	}
+}
+
+// flush indexes a batch of samples, logging rather than failing on errors.
+func (s *sampleSink) flush(batch []interface{}) {
+	if len(batch) == 0 {
+		return
+	}
+	s.batches++
+	name := fmt.Sprintf("samples-%s-%s-%d", s.phase, s.conf.RUNID, s.batches)
+	if _, err := s.conf.Samples.Index(s.ctx, batch, name); err != nil {
+		s.failed += uint64(len(batch))
+		zlog.Warn(s.ctx).Err(err).Str("phase", s.phase).Int("samples", len(batch)).Msg("could not index samples")
+		return
+	}
+	s.indexed += uint64(len(batch))
+}
+
+// newSample converts a vegeta result into an indexable sample.
+// It returns the sample.
+func (s *sampleSink) newSample(res *vegeta.Result) Sample {
+	return Sample{
+		RunID:        s.conf.RUNID,
+		Phase:        s.phase,
+		Seq:          res.Seq,
+		Method:       res.Method,
+		ManifestHash: manifestHashOf(res),
+		Status:       res.Code,
+		Latency:      res.Latency,
+		Timestamp:    res.Timestamp.Format(timestampFormat),
+		Error:        res.Error,
+	}
+}
+
+// manifestHashOf works out which manifest a request was about, either from the
+// request path or, for index report creation, from the returned index report.
+// It returns an empty string for requests not tied to a manifest.
+func manifestHashOf(res *vegeta.Result) string {
+	if u, err := url.Parse(res.URL); err == nil {
+		if seg := path.Base(u.Path); strings.Contains(seg, ":") {
+			return seg
+		}
+	}
+	if res.Method == http.MethodPost && len(res.Body) > 0 {
+		var report struct {
+			Hash string `json:"manifest_hash"`
+		}
+		if err := json.Unmarshal(res.Body, &report); err == nil {
+			return report.Hash
+		}
+	}
+	return ""
+}
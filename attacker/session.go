@@ -0,0 +1,415 @@
+package attacker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Steps of a session
+const (
+	StepPostIndexReport        = "post_index_report"
+	StepPollIndexReport        = "poll_index_report"
+	StepGetVulnerabilityReport = "get_vulnerability_report"
+	StepIndexed                = "indexed"
+	StepEndToEnd               = "end_to_end"
+)
+
+// States of an index report
+const (
+	indexFinished = "IndexFinished"
+	indexError    = "IndexError"
+)
+
+// DefaultSessionBuckets are the upper bounds of the latency histograms of sessions.
+var DefaultSessionBuckets = []time.Duration{
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute,
+}
+
+// Type used to follow the chain of requests of a single image.
+type session struct {
+	hash     string
+	manifest []byte
+	started  time.Time
+	attempts int
+}
+
+// Type used to schedule the next request of a session.
+type sessionTask struct {
+	session int
+	step    string
+	due     time.Time
+}
+
+// Type used to gather the latencies of a step.
+type stepRecorder struct {
+	latencies []time.Duration
+	failed    uint64
+}
+
+// Type used to drive the session workload: every virtual user takes an image and runs its whole
+// chain, POST, polling and vulnerability report, before taking the next one.
+type sessionRun struct {
+	ctx      context.Context
+	sconf    SessionConfig
+	conf     *AttackConfig
+	sessions []*session
+	deadline <-chan time.Time
+	ready    chan sessionTask
+	wake     chan struct{}
+	done     chan struct{}
+
+	mu       sync.Mutex
+	queue    []sessionTask
+	started  int
+	finished int
+	summary  SessionSummary
+	steps    map[string]*stepRecorder
+}
+
+// Pace blocks until the request of a session is due and hands it to the targeter.
+// It stops the attack once every session is over, the deadline passed or ctx is done.
+func (r *sessionRun) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	for {
+		task, wait, ok := r.due()
+		if ok {
+			r.ready <- task
+			return 0, false
+		}
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+		stop := false
+		select {
+		case <-fire:
+		case <-r.wake:
+		case <-r.done:
+			stop = true
+		case <-r.deadline:
+			stop = true
+		case <-r.ctx.Done():
+			stop = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if stop {
+			return 0, true
+		}
+	}
+}
+
+// Rate returns the number of virtual users.
+func (r *sessionRun) Rate(elapsed time.Duration) float64 {
+	return float64(r.sconf.Users)
+}
+
+// due takes the earliest request that is due.
+// It returns the request and true, or how long until the earliest one is due and false,
+// zero when none is scheduled.
+func (r *sessionRun) due() (sessionTask, time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 {
+		return sessionTask{}, 0, false
+	}
+	first := 0
+	for i, t := range r.queue {
+		if t.due.Before(r.queue[first].due) {
+			first = i
+		}
+	}
+	task := r.queue[first]
+	if wait := time.Until(task.due); wait > 0 {
+		return sessionTask{}, wait, false
+	}
+	r.queue = append(r.queue[:first], r.queue[first+1:]...)
+	return task, 0, true
+}
+
+// schedule queues the next request of a session and wakes the pacer up. The lock must be held.
+func (r *sessionRun) schedule(idx int, step string, after time.Duration) {
+	r.queue = append(r.queue, sessionTask{session: idx, step: step, due: time.Now().Add(after)})
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startNext gives the next image to a virtual user, if any is left. The lock must be held.
+func (r *sessionRun) startNext() {
+	if r.started == len(r.sessions) {
+		return
+	}
+	r.schedule(r.started, StepPostIndexReport, 0)
+	r.started++
+}
+
+// targeter builds the request of the task handed out by the pacer. The session is kept in the
+// URL fragment, which is never sent, to tell the results apart.
+// It returns an error if the token could not be issued.
+func (r *sessionRun) targeter() vegeta.Targeter {
+	return func(tgt *vegeta.Target) error {
+		task := <-r.ready
+		s := r.sessions[task.session]
+		switch task.step {
+		case StepPostIndexReport:
+			tgt.Method = http.MethodPost
+			tgt.URL = r.conf.Host + "/indexer/api/v1/index_report"
+			tgt.Body = s.manifest
+		case StepPollIndexReport:
+			tgt.Method = http.MethodGet
+			tgt.URL = r.conf.Host + "/indexer/api/v1/index_report/" + s.hash
+		case StepGetVulnerabilityReport:
+			tgt.Method = http.MethodGet
+			tgt.URL = r.conf.Host + "/matcher/api/v1/vulnerability_report/" + s.hash
+		}
+		tgt.URL += "#" + strconv.Itoa(task.session)
+		tgt.Header = http.Header{"Content-Type": []string{"application/json"}}
+		if r.conf.Auth != nil {
+			tok, err := r.conf.Auth.Next()
+			if err != nil {
+				return err
+			}
+			tgt.Header = withToken(tgt.Header, tok)
+		}
+		return nil
+	}
+}
+
+// stepOf works out the step and the session a result belongs to.
+// It returns the step, the session and whether the result belongs to one.
+func (r *sessionRun) stepOf(res *vegeta.Result) (string, int, bool) {
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		return "", 0, false
+	}
+	idx, err := strconv.Atoi(u.Fragment)
+	if err != nil || idx < 0 || idx >= len(r.sessions) {
+		return "", 0, false
+	}
+	switch {
+	case res.Method == http.MethodPost:
+		return StepPostIndexReport, idx, true
+	case strings.Contains(u.Path, "/index_report/"):
+		return StepPollIndexReport, idx, true
+	case strings.Contains(u.Path, "/vulnerability_report/"):
+		return StepGetVulnerabilityReport, idx, true
+	}
+	return "", 0, false
+}
+
+// observe moves a session on to its next step, retries the failed step or gives up on it.
+func (r *sessionRun) observe(res *vegeta.Result) {
+	step, idx, ok := r.stepOf(res)
+	if !ok {
+		return
+	}
+	end := res.Timestamp.Add(res.Latency)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.sessions[idx]
+	if s.started.IsZero() {
+		s.started = res.Timestamp
+	}
+	rec := r.step(step)
+	rec.latencies = append(rec.latencies, res.Latency)
+	if step == StepPollIndexReport {
+		r.summary.Polls++
+	}
+
+	// Transient failures are retried the way Quay's security worker does
+	if res.Code == 0 || res.Code >= 500 || res.Code == http.StatusTooManyRequests ||
+		(step == StepGetVulnerabilityReport && res.Code == http.StatusNotFound) {
+		rec.failed++
+		if s.attempts < r.sconf.Retries {
+			s.attempts++
+			r.summary.Retries++
+			r.schedule(idx, step, r.sconf.RetryBackoff)
+			return
+		}
+		r.end(idx, false)
+		return
+	}
+
+	switch step {
+	case StepPostIndexReport, StepPollIndexReport:
+		if step == StepPollIndexReport && res.Code == http.StatusNotFound {
+			r.poll(idx, end)
+			return
+		}
+		if res.Code < 200 || res.Code >= 300 {
+			rec.failed++
+			r.end(idx, false)
+			return
+		}
+		var report struct {
+			State string `json:"state"`
+		}
+		json.Unmarshal(res.Body, &report)
+		switch report.State {
+		case indexFinished:
+			s.attempts = 0
+			r.step(StepIndexed).latencies = append(r.step(StepIndexed).latencies, end.Sub(s.started))
+			r.schedule(idx, StepGetVulnerabilityReport, 0)
+		case indexError:
+			rec.failed++
+			r.end(idx, false)
+		default:
+			r.poll(idx, end)
+		}
+	case StepGetVulnerabilityReport:
+		if res.Code != http.StatusOK {
+			rec.failed++
+			r.end(idx, false)
+			return
+		}
+		r.step(StepEndToEnd).latencies = append(r.step(StepEndToEnd).latencies, end.Sub(s.started))
+		r.end(idx, true)
+	}
+}
+
+// poll checks the index report of a session again after the poll interval, or gives up
+// once indexing has taken longer than the poll timeout. The lock must be held.
+func (r *sessionRun) poll(idx int, now time.Time) {
+	s := r.sessions[idx]
+	if r.sconf.PollTimeout > 0 && now.Sub(s.started) > r.sconf.PollTimeout {
+		r.step(StepIndexed).failed++
+		r.end(idx, false)
+		return
+	}
+	s.attempts = 0
+	r.schedule(idx, StepPollIndexReport, r.sconf.PollInterval)
+}
+
+// end closes a session and hands the next image to its virtual user. The lock must be held.
+func (r *sessionRun) end(idx int, completed bool) {
+	if completed {
+		r.summary.Completed++
+	} else {
+		r.summary.Failed++
+		r.step(StepEndToEnd).failed++
+		zlog.Debug(r.ctx).Str("manifest_hash", r.sessions[idx].hash).Msg("session failed")
+	}
+	r.finished++
+	if r.finished == len(r.sessions) {
+		close(r.done)
+		return
+	}
+	r.startNext()
+}
+
+// step returns the recorder of a step. The lock must be held.
+func (r *sessionRun) step(name string) *stepRecorder {
+	rec, ok := r.steps[name]
+	if !ok {
+		rec = &stepRecorder{}
+		r.steps[name] = rec
+	}
+	return rec
+}
+
+// decorate adds the session summary to the document of the phase.
+func (r *sessionRun) decorate(doc *Document) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.summary
+	s.Users = r.sconf.Users
+	s.Sessions = uint64(len(r.sessions))
+	s.Steps = make(map[string]StepSummary, len(r.steps))
+	for name, rec := range r.steps {
+		s.Steps[name] = StepSummary{
+			Count:     uint64(len(rec.latencies)),
+			Failed:    rec.failed,
+			Latency:   newDurationSummary(rec.latencies),
+			Histogram: newHistogram(rec.latencies, r.sconf.Buckets),
+		}
+	}
+	doc.Session = &s
+}
+
+// RunSessions runs one session per image: POST its index report, poll it until indexing is
+// finished, then GET its vulnerability report, retrying the transient failures of every step.
+// The configured number of virtual users run their sessions side by side.
+// It returns an error if any during the execution.
+func RunSessions(ctx context.Context, manifests [][]byte, manifestHashes []string, sconf SessionConfig, testName string, conf *AttackConfig) error {
+	warnLocal(ctx, testName, conf)
+	if sconf.Users <= 0 {
+		sconf.Users = conf.Concurrency
+	}
+	if len(sconf.Buckets) == 0 {
+		sconf.Buckets = DefaultSessionBuckets
+	}
+	r := &sessionRun{
+		ctx:   ctx,
+		sconf: sconf,
+		conf:  conf,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+		steps: map[string]*stepRecorder{},
+	}
+	seen := map[string]bool{}
+	for i, m := range manifests {
+		if i >= len(manifestHashes) || seen[manifestHashes[i]] {
+			continue
+		}
+		seen[manifestHashes[i]] = true
+		r.sessions = append(r.sessions, &session{hash: manifestHashes[i], manifest: m})
+	}
+	if len(r.sessions) == 0 {
+		return fmt.Errorf("no image to run sessions for")
+	}
+	r.ready = make(chan sessionTask, len(r.sessions))
+	for u := 0; u < sconf.Users; u++ {
+		r.startNext()
+	}
+	if conf.Duration > 0 {
+		timer := time.NewTimer(conf.Duration)
+		defer timer.Stop()
+		r.deadline = timer.C
+	}
+	return runPhase(ctx, phase{
+		name: testName,
+		plan: Plan{Rate: sconf.Users, Duration: conf.Duration},
+		start: func(ctx context.Context) (<-chan *vegeta.Result, func() error, error) {
+			results, err := attackPaced(ctx, r.targeter(), r, conf.Duration, conf.Transport, testName)
+			return results, func() error { return nil }, err
+		},
+		observe:  r.observe,
+		decorate: r.decorate,
+	}, conf)
+}
+
+// newHistogram counts the durations falling in every bucket, the last one being unbounded.
+// It returns the buckets.
+func newHistogram(ds []time.Duration, bounds []time.Duration) []HistogramBucket {
+	buckets := make([]HistogramBucket, len(bounds)+1)
+	var from time.Duration
+	for i, to := range bounds {
+		buckets[i] = HistogramBucket{From: from, To: to}
+		from = to
+	}
+	buckets[len(bounds)] = HistogramBucket{From: from}
+	for _, d := range ds {
+		i := 0
+		for i < len(bounds) && d >= bounds[i] {
+			i++
+		}
+		buckets[i].Count++
+	}
+	return buckets
+}
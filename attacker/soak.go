@@ -0,0 +1,317 @@
+package attacker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Constants
+const (
+	// soakMinWindows is how many windows it takes before a trend can be told from noise
+	soakMinWindows = 3
+)
+
+// ErrSoakOver is the cause of the cancellation of the phase running when a soak ends.
+var ErrSoakOver = errors.New("soak duration reached")
+
+// Type used to cut a soak into windows: every result goes into the window it arrived in,
+// and every window is summarised, as a whole and phase by phase, and indexed once over.
+// Phases are told apart so that their trends do not depend on which of them a window caught.
+type Soak struct {
+	ctx   context.Context
+	sconf SoakConfig
+	conf  *AttackConfig
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+	start time.Time
+
+	mu      sync.Mutex
+	cycle   int
+	begin   time.Time
+	metrics *vegeta.Metrics
+	phases  map[string]*vegeta.Metrics
+	windows []SoakWindow
+}
+
+// StartSoak starts cutting the results of the run into windows of the configured length.
+// It returns the soak, which must be closed once the run is over.
+func StartSoak(ctx context.Context, sconf SoakConfig, conf *AttackConfig) *Soak {
+	now := time.Now()
+	s := &Soak{
+		ctx:     Detach(ctx),
+		sconf:   sconf,
+		conf:    conf,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		start:   now,
+		begin:   now,
+		metrics: &vegeta.Metrics{},
+		phases:  map[string]*vegeta.Metrics{},
+	}
+	go s.run()
+	return s
+}
+
+// run closes a window every window length until the soak is closed.
+func (s *Soak) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.sconf.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.flush(now)
+		}
+	}
+}
+
+// NextCycle records that the workload starts over.
+func (s *Soak) NextCycle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycle++
+}
+
+// Add counts a result of a phase in the current window.
+func (s *Soak) Add(phase string, res *vegeta.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.Add(res)
+	m, ok := s.phases[phase]
+	if !ok {
+		m = &vegeta.Metrics{}
+		s.phases[phase] = m
+	}
+	m.Add(res)
+}
+
+// flush closes the current window, then summarises, checks for trends and indexes it.
+func (s *Soak) flush(end time.Time) {
+	s.mu.Lock()
+	metrics, phases, begin, cycle := s.metrics, s.phases, s.begin, s.cycle
+	s.metrics, s.phases, s.begin = &vegeta.Metrics{}, map[string]*vegeta.Metrics{}, end
+	s.mu.Unlock()
+
+	metrics.Close()
+	w := SoakWindow{
+		SchemaVersion: DocumentSchemaVersion,
+		Workload:      "clair-load-test",
+		RunID:         s.conf.RUNID,
+		Window:        len(s.windows) + 1,
+		Cycle:         cycle,
+		StartTime:     begin.Format(timestampFormat),
+		EndTime:       end.Format(timestampFormat),
+		Duration:      end.Sub(begin),
+		Requests:      metrics.Requests,
+		Rate:          metrics.Rate,
+		Throughput:    metrics.Throughput,
+		Success:       metrics.Success,
+		StatusCodes:   metrics.StatusCodes,
+		Latency:       newLatencySummary(metrics),
+		Errors:        metrics.Errors,
+		Phases:        []string{},
+		Breakdown:     []SoakPhase{},
+	}
+	if metrics.Requests > 0 {
+		w.ErrorRate = 1 - metrics.Success
+	}
+	for p := range phases {
+		w.Phases = append(w.Phases, p)
+	}
+	sort.Strings(w.Phases)
+	for _, p := range w.Phases {
+		m := phases[p]
+		m.Close()
+		b := SoakPhase{
+			Phase:       p,
+			Requests:    m.Requests,
+			Throughput:  m.Throughput,
+			Success:     m.Success,
+			StatusCodes: m.StatusCodes,
+			Latency:     newLatencySummary(m),
+		}
+		if m.Requests > 0 {
+			b.ErrorRate = 1 - m.Success
+		}
+		w.Breakdown = append(w.Breakdown, b)
+	}
+	s.windows = append(s.windows, w)
+	var trends []SoakTrends
+	for i := range w.Breakdown {
+		w.Breakdown[i].SoakTrends = s.trends(w.Breakdown[i].Phase)
+		trends = append(trends, w.Breakdown[i].SoakTrends)
+	}
+	w.SoakTrends = steepest(trends)
+	s.windows[len(s.windows)-1] = w
+
+	log := zlog.Info(s.ctx)
+	if w.LatencyTrendFlagged || w.ErrorTrendFlagged {
+		log = zlog.Warn(s.ctx)
+	}
+	var flagged []string
+	for _, b := range w.Breakdown {
+		if b.LatencyTrendFlagged || b.ErrorTrendFlagged {
+			flagged = append(flagged, b.Phase)
+		}
+	}
+	log.Int("window", w.Window).
+		Int("cycle", w.Cycle).
+		Uint64("requests", w.Requests).
+		Float64("throughput", w.Throughput).
+		Stringer("p95", w.Latency.P95).
+		Float64("error_rate", w.ErrorRate).
+		Float64("latency_trend", w.LatencyTrend).
+		Float64("error_trend", w.ErrorTrend).
+		Bool("latency_trend_flagged", w.LatencyTrendFlagged).
+		Bool("error_trend_flagged", w.ErrorTrendFlagged).
+		Strs("flagged_phases", flagged).
+		Msg("Soak window closed")
+	if s.conf.Indexer != nil {
+		name := fmt.Sprintf("soak_window_%d-%s", w.Window, s.conf.RUNID)
+		if _, err := s.conf.Indexer.Index(s.ctx, []interface{}{w}, name); err != nil {
+			zlog.Warn(s.ctx).Err(err).Int("window", w.Window).Msg("could not index soak window")
+		}
+	}
+}
+
+// trends fits a line through the p95 latencies and the error rates of a phase in the windows
+// it sent requests in, and flags the growth across them beyond the configured thresholds.
+// It returns the trends, with the latency one relative to the fitted p95 of the first window.
+func (s *Soak) trends(phase string) SoakTrends {
+	var windows, p95s, errs []float64
+	for _, w := range s.windows {
+		for _, b := range w.Breakdown {
+			if b.Phase == phase && b.Requests > 0 {
+				windows = append(windows, float64(w.Window))
+				p95s = append(p95s, float64(b.Latency.P95))
+				errs = append(errs, b.ErrorRate)
+			}
+		}
+	}
+	var out SoakTrends
+	if first, change := fitTrend(windows, p95s); first > 0 {
+		out.LatencyTrend = change / first
+	}
+	_, out.ErrorTrend = fitTrend(windows, errs)
+	if len(p95s) >= soakMinWindows {
+		out.LatencyTrendFlagged = out.LatencyTrend > s.sconf.LatencyTrend
+		out.ErrorTrendFlagged = out.ErrorTrend > s.sconf.ErrorTrend
+	}
+	return out
+}
+
+// steepest combines the trends of several phases: the steepest growth of either kind,
+// flagged when any phase was.
+// It returns the combined trends.
+func steepest(trends []SoakTrends) SoakTrends {
+	var out SoakTrends
+	for i, t := range trends {
+		if i == 0 || t.LatencyTrend > out.LatencyTrend {
+			out.LatencyTrend = t.LatencyTrend
+		}
+		if i == 0 || t.ErrorTrend > out.ErrorTrend {
+			out.ErrorTrend = t.ErrorTrend
+		}
+		out.LatencyTrendFlagged = out.LatencyTrendFlagged || t.LatencyTrendFlagged
+		out.ErrorTrendFlagged = out.ErrorTrendFlagged || t.ErrorTrendFlagged
+	}
+	return out
+}
+
+// fitTrend fits a line through values taken at the given windows, by least squares.
+// It returns the fitted first value and the change of the line from the first window to the last.
+func fitTrend(windows, values []float64) (float64, float64) {
+	n := float64(len(values))
+	if n < 2 {
+		return 0, 0
+	}
+	var sx, sy, sxx, sxy float64
+	for i, v := range values {
+		x := windows[i] - windows[0]
+		sx += x
+		sy += v
+		sxx += x * x
+		sxy += x * v
+	}
+	slope := (n*sxy - sx*sy) / (n*sxx - sx*sx)
+	intercept := (sy - slope*sx) / n
+	return intercept, slope * (windows[len(windows)-1] - windows[0])
+}
+
+// Close closes the last window if it holds any request and indexes the summary of the soak.
+// It returns the summary.
+func (s *Soak) Close() SoakSummary {
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.mu.Lock()
+		pending := s.metrics.Requests
+		s.mu.Unlock()
+		if pending > 0 {
+			s.flush(time.Now())
+		}
+	})
+	end := time.Now()
+	s.mu.Lock()
+	cycles := s.cycle
+	s.mu.Unlock()
+	summary := SoakSummary{
+		SchemaVersion: DocumentSchemaVersion,
+		Workload:      "clair-load-test",
+		RunID:         s.conf.RUNID,
+		Config:        s.sconf,
+		StartTime:     s.start.Format(timestampFormat),
+		EndTime:       end.Format(timestampFormat),
+		Duration:      end.Sub(s.start),
+		Cycles:        cycles,
+		PhaseTrends:   []SoakPhaseTrend{},
+	}
+	seen := map[string]bool{}
+	var phases []string
+	for _, w := range s.windows {
+		for _, b := range w.Breakdown {
+			summary.Windows = append(summary.Windows, SoakTrendPoint{Window: w.Window, Phase: b.Phase, Requests: b.Requests, P95Latency: b.Latency.P95, ErrorRate: b.ErrorRate})
+			if !seen[b.Phase] {
+				seen[b.Phase] = true
+				phases = append(phases, b.Phase)
+			}
+		}
+		summary.Requests += w.Requests
+	}
+	sort.Strings(phases)
+	var trends []SoakTrends
+	for _, p := range phases {
+		t := s.trends(p)
+		summary.PhaseTrends = append(summary.PhaseTrends, SoakPhaseTrend{Phase: p, SoakTrends: t})
+		trends = append(trends, t)
+	}
+	summary.SoakTrends = steepest(trends)
+	log := zlog.Info(s.ctx)
+	if summary.LatencyTrendFlagged || summary.ErrorTrendFlagged {
+		log = zlog.Warn(s.ctx)
+	}
+	log.Int("windows", len(summary.Windows)).
+		Int("cycles", summary.Cycles).
+		Uint64("requests", summary.Requests).
+		Float64("latency_trend", summary.LatencyTrend).
+		Float64("error_trend", summary.ErrorTrend).
+		Bool("latency_trend_flagged", summary.LatencyTrendFlagged).
+		Bool("error_trend_flagged", summary.ErrorTrendFlagged).
+		Msg("Soak over")
+	if s.conf.Indexer != nil {
+		if _, err := s.conf.Indexer.Index(s.ctx, []interface{}{summary}, "soak-"+s.conf.RUNID); err != nil {
+			zlog.Warn(s.ctx).Err(err).Msg("could not index soak summary")
+		}
+	}
+	return summary
+}
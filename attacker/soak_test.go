@@ -0,0 +1,54 @@
+package attacker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// soakWindows runs a soak through windows closed by hand, made of the requests the function
+// adds to every one of them with their phase and latency.
+// It returns the summary of the soak.
+func soakWindows(windows int, add func(w int, add func(phase string, latency time.Duration, n int))) SoakSummary {
+	now := time.Now()
+	s := StartSoak(context.Background(), SoakConfig{Window: time.Hour, LatencyTrend: 0.2, ErrorTrend: 0.01}, &AttackConfig{RUNID: "run"})
+	for w := 1; w <= windows; w++ {
+		add(w, func(phase string, latency time.Duration, n int) {
+			for i := 0; i < n; i++ {
+				s.Add(phase, &vegeta.Result{Code: 200, Timestamp: now, Latency: latency})
+			}
+		})
+		s.flush(now.Add(time.Duration(w) * time.Minute))
+	}
+	return s.Close()
+}
+
+func TestSoakTrendsPerPhase(t *testing.T) {
+	// Fast posts and slow reports steady over time, but caught by the windows in a changing mix
+	summary := soakWindows(6, func(w int, add func(string, time.Duration, int)) {
+		add("post_index_report", 10*time.Millisecond, 100-15*w)
+		add("get_vulnerability_report", time.Second, 15*w)
+	})
+	if summary.LatencyTrendFlagged || summary.LatencyTrend != 0 {
+		t.Errorf("got a latency trend of %v, flagged %v, want none for steady phases", summary.LatencyTrend, summary.LatencyTrendFlagged)
+	}
+	if len(summary.PhaseTrends) != 2 || len(summary.Windows) != 12 {
+		t.Errorf("got %d phase trends and %d trend points, want one per phase and window", len(summary.PhaseTrends), len(summary.Windows))
+	}
+
+	// One phase getting slower is flagged on its own
+	summary = soakWindows(6, func(w int, add func(string, time.Duration, int)) {
+		add("post_index_report", time.Duration(10*w)*time.Millisecond, 50)
+		add("get_vulnerability_report", time.Second, 50)
+	})
+	if !summary.LatencyTrendFlagged {
+		t.Errorf("got %+v, want the latency trend flagged", summary.SoakTrends)
+	}
+	for _, p := range summary.PhaseTrends {
+		if flagged := p.Phase == "post_index_report"; p.LatencyTrendFlagged != flagged {
+			t.Errorf("phase %s: got the latency trend flagged %v, want %v", p.Phase, p.LatencyTrendFlagged, flagged)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package attacker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// DefaultTransport mirrors vegeta's defaults, with the long request timeout clair needs
+// to index large manifests.
+var DefaultTransport = Transport{
+	Timeout:         requestTimeout,
+	KeepAlive:       true,
+	IdleConnections: vegeta.DefaultConnections,
+	HTTP2:           true,
+	Redirects:       vegeta.DefaultRedirects,
+}
+
+// tlsConfig builds the TLS settings used to talk to clair.
+// It returns the TLS config and an error if any of the files could not be loaded.
+func (t Transport) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CABundle != "" {
+		pem, err := os.ReadFile(t.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", t.CABundle)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.ClientCert != "" || t.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// options converts the transport settings into vegeta attacker options.
+// It returns the options and an error if any of the settings is invalid.
+func (t Transport) options() ([]func(*vegeta.Attacker), error) {
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = requestTimeout
+	}
+	tlsConf, err := t.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts := []func(*vegeta.Attacker){
+		vegeta.Timeout(timeout),
+		vegeta.KeepAlive(t.KeepAlive),
+		vegeta.Redirects(t.Redirects),
+		vegeta.TLSConfig(tlsConf),
+	}
+	if t.MaxConnections > 0 {
+		opts = append(opts, vegeta.MaxConnections(t.MaxConnections))
+	}
+	if t.IdleConnections > 0 {
+		opts = append(opts, vegeta.Connections(t.IdleConnections))
+	}
+	if t.Proxy != "" {
+		u, err := url.Parse(t.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		opts = append(opts, vegeta.Proxy(http.ProxyURL(u)))
+	}
+	if t.LocalAddr != "" {
+		ip := net.ParseIP(t.LocalAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local address %q", t.LocalAddr)
+		}
+		opts = append(opts, vegeta.LocalAddr(net.IPAddr{IP: ip}))
+	}
+	// HTTP/2 settings come last, h2c swaps the whole transport out.
+	opts = append(opts, vegeta.HTTP2(t.HTTP2))
+	if t.H2C {
+		opts = append(opts, vegeta.H2C(true))
+	}
+	return opts, nil
+}
+
+// Validate makes sure the transport can be built before a run starts.
+// It returns an error if any of the settings is invalid.
+func (t Transport) Validate() error {
+	_, err := t.options()
+	return err
+}
@@ -1,27 +1,422 @@
 package attacker
 
 import (
+	"context"
 	"time"
+
+	"github.com/quay/clair-load-test/auth"
+	"github.com/quay/clair-load-test/clairmetrics"
+	"github.com/quay/clair-load-test/faultproxy"
+	"github.com/quay/clair-load-test/indexer"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Types of targeters
+const (
+	TargeterCycle   = "cycle"
+	TargeterExhaust = "exhaust"
+)
+
+// How the arrivals of an attack are spaced
+const (
+	ArrivalsConstant = "constant"
+	ArrivalsPoisson  = "poisson"
+	ArrivalsTrace    = "trace"
+)
+
+// Sources of the vulnerabilities sent to affected_manifest
+const (
+	VulnerabilitiesSynthetic = "synthetic"
+	VulnerabilitiesHarvested = "harvested"
+)
+
+// Endpoints probed by the availability workload
+const (
+	ProbeIndexReport         = "index_report"
+	ProbeIndexState          = "index_state"
+	ProbeVulnerabilityReport = "vulnerability_report"
+	ProbeUpdateOperation     = "update_operation"
+	ProbeNotification        = "notification"
 )
 
-// Type used to index results to elastic search.
+// ProbeEndpoints lists every endpoint the availability workload can probe.
+var ProbeEndpoints = []string{ProbeIndexReport, ProbeIndexState, ProbeVulnerabilityReport, ProbeUpdateOperation, ProbeNotification}
+
+// DocumentSchemaVersion is bumped whenever fields of Document change meaning or are removed.
+const DocumentSchemaVersion = 2
+
+// Type used to store the run wide settings shared by every attack.
+type AttackConfig struct {
+	RUNID             string
+	Concurrency       int
+	Host              string
+	ClairMetricsURL   string
+	ProxyStatsURL     string
+	Indexer           indexer.Indexer
+	Samples           indexer.Indexer
+	SamplesBatchSize  int
+	SamplesBufferSize int
+	ToolVersion       string
+	ClairVersion      string
+	TestConfig        interface{}
+	Workers           []string
+	WorkerSecret      string
+	Requests          uint64
+	Duration          time.Duration
+	Targeter          string
+	Arrivals          string
+	Trace             []time.Duration
+	TraceSpeed        float64
+	Transport         Transport
+	Auth              *auth.Issuer
+	Soak              *Soak
+}
+
+// Type used to configure how the attacker talks to clair.
+// File paths are resolved on the host running the attack.
+type Transport struct {
+	Timeout            time.Duration `json:"timeout"`
+	KeepAlive          bool          `json:"keepalive"`
+	MaxConnections     int           `json:"max_connections"`
+	IdleConnections    int           `json:"idle_connections"`
+	HTTP2              bool          `json:"http2"`
+	H2C                bool          `json:"h2c"`
+	Redirects          int           `json:"redirects"`
+	Proxy              string        `json:"proxy,omitempty"`
+	CABundle           string        `json:"ca_bundle,omitempty"`
+	ClientCert         string        `json:"client_cert,omitempty"`
+	ClientKey          string        `json:"client_key,omitempty"`
+	InsecureSkipVerify bool          `json:"insecure_skip_verify"`
+	LocalAddr          string        `json:"local_addr,omitempty"`
+}
+
+// Type used to describe the pace and the length of an attack.
+// Rate is the mean rate of Poisson arrivals, while trace driven arrivals follow the offsets
+// of the trace divided by the speed.
+type Plan struct {
+	Rate     int             `json:"rate"`
+	Hits     uint64          `json:"hits"`
+	Duration time.Duration   `json:"duration"`
+	Arrivals string          `json:"arrivals,omitempty"`
+	Trace    []time.Duration `json:"trace,omitempty"`
+	Speed    float64         `json:"speed,omitempty"`
+}
+
+// Type used to plug a workload into the measurement, reporting and indexing of a phase.
+type phase struct {
+	name string
+	plan Plan
+	// start launches the attack, returning its results and a function reporting
+	// the failure of the attack, if any, once the results are drained.
+	start func(ctx context.Context) (<-chan *vegeta.Result, func() error, error)
+	// observe, when set, sees every result as it arrives.
+	observe func(*vegeta.Result)
+	// decorate, when set, completes the document before it is indexed.
+	decorate func(*Document)
+}
+
+// Type used to hand a share of a phase over to a worker.
+type WorkerJob struct {
+	RunID      string          `json:"run_id"`
+	Phase      string          `json:"phase"`
+	Targets    []vegeta.Target `json:"targets"`
+	Plan       Plan            `json:"plan"`
+	Transport  Transport       `json:"transport"`
+	StartAt    time.Time       `json:"start_at"`
+	KeepBodies bool            `json:"keep_bodies"`
+	Auth       *auth.Config    `json:"auth,omitempty"`
+	PSK        string          `json:"psk,omitempty"`
+}
+
+// Type used to index results.
 type Document struct {
-	Workload       string         `json:"workload"`
-	Endpoint       string         `json:"endpoint"`
-	RequestTimeout int            `json:"request_timeout"`
-	Targets        string         `json:"targets"`
-	Hostname       string         `json:"hostname"`
-	RPS            int            `json:"rps"`
-	Throughput     float64        `json:"throughput"`
-	StatusCodes    map[string]int `json:"status_codes"`
-	Requests       uint64         `json:"requests"`
-	P99Latency     time.Duration  `json:"p99_latency"`
-	P95Latency     time.Duration  `json:"p95_latency"`
-	MaxLatency     time.Duration  `json:"max_latency"`
-	MinLatency     time.Duration  `json:"min_latency"`
-	ReqLatency     time.Duration  `json:"req_latency"`
-	Timestamp      string         `json:"timestamp"`
-	BytesIn        float64        `json:"bytes_in"`
-	BytesOut       float64        `json:"bytes_out"`
-	RunID          string         `json:"run_id"`
+	SchemaVersion   int                  `json:"schema_version"`
+	Workload        string               `json:"workload"`
+	ToolVersion     string               `json:"tool_version"`
+	ClairVersion    string               `json:"clair_version,omitempty"`
+	Endpoint        string               `json:"endpoint"`
+	RequestTimeout  int                  `json:"request_timeout"`
+	Targets         string               `json:"targets"`
+	Hostname        string               `json:"hostname"`
+	Config          interface{}          `json:"config,omitempty"`
+	TargetRate      int                  `json:"target_rate"`
+	AchievedRate    float64              `json:"achieved_rate"`
+	Throughput      float64              `json:"throughput"`
+	Success         float64              `json:"success"`
+	StatusCodes     map[string]int       `json:"status_codes"`
+	PlannedRequests uint64               `json:"planned_requests"`
+	Requests        uint64               `json:"requests"`
+	P99Latency      time.Duration        `json:"p99_latency"`
+	P95Latency      time.Duration        `json:"p95_latency"`
+	MaxLatency      time.Duration        `json:"max_latency"`
+	MinLatency      time.Duration        `json:"min_latency"`
+	ReqLatency      time.Duration        `json:"req_latency"`
+	Timestamp       string               `json:"timestamp"`
+	StartTime       string               `json:"start_time"`
+	EndTime         string               `json:"end_time"`
+	Duration        time.Duration        `json:"duration"`
+	Errors          []string             `json:"errors"`
+	BytesIn         float64              `json:"bytes_in"`
+	BytesOut        float64              `json:"bytes_out"`
+	RunID           string               `json:"run_id"`
+	ClairMetrics    *clairmetrics.Delta  `json:"clair_metrics,omitempty"`
+	Faults          *faultproxy.Stats    `json:"faults,omitempty"`
+	SamplesDropped  uint64               `json:"samples_dropped"`
+	Auth            *AuthSummary         `json:"auth,omitempty"`
+	Aborted         bool                 `json:"aborted"`
+	AbortReason     string               `json:"abort_reason,omitempty"`
+	Notifier        *NotifierSummary     `json:"notifier,omitempty"`
+	Session         *SessionSummary      `json:"session,omitempty"`
+	Replay          *ReplaySummary       `json:"replay,omitempty"`
+	Availability    *AvailabilitySummary `json:"availability,omitempty"`
+}
+
+// Type used to tell the requests clair rejected for their token apart from the others.
+type AuthSummary struct {
+	Scope           string            `json:"scope"`
+	Injected        map[string]uint64 `json:"injected"`
+	Rejected        uint64            `json:"rejected"`
+	RejectedLatency LatencySummary    `json:"rejected_latency"`
+	AcceptedLatency LatencySummary    `json:"accepted_latency"`
+}
+
+// Type used to summarise the latencies of a subset of the requests.
+type LatencySummary struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P95  time.Duration `json:"p95"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+// Type used to configure the notifier workload.
+type NotifierConfig struct {
+	PageSize      int           `json:"page_size"`
+	Notifications int           `json:"notifications"`
+	Duration      time.Duration `json:"duration"`
+	Delete        bool          `json:"delete"`
+}
+
+// Type used as the body of the callbacks clair's notifier delivers to its webhook.
+type Callback struct {
+	NotificationID string    `json:"notification_id"`
+	Callback       string    `json:"callback"`
+	Received       time.Time `json:"-"`
+}
+
+// Type used to summarise how the notifications clair called back for were handled.
+type NotifierSummary struct {
+	Callbacks             uint64         `json:"callbacks"`
+	CallbacksDropped      uint64         `json:"callbacks_dropped"`
+	Completed             uint64         `json:"completed"`
+	Failed                uint64         `json:"failed"`
+	InFlight              uint64         `json:"in_flight"`
+	Retries               uint64         `json:"retries"`
+	Pages                 uint64         `json:"pages"`
+	Notifications         uint64         `json:"notifications"`
+	Deleted               uint64         `json:"deleted"`
+	CallbackToRetrievable LatencySummary `json:"callback_to_retrievable"`
+}
+
+// Type used to configure the session workload.
+type SessionConfig struct {
+	Users        int             `json:"users"`
+	PollInterval time.Duration   `json:"poll_interval"`
+	PollTimeout  time.Duration   `json:"poll_timeout"`
+	Retries      int             `json:"retries"`
+	RetryBackoff time.Duration   `json:"retry_backoff"`
+	Buckets      []time.Duration `json:"buckets,omitempty"`
+}
+
+// Type used to summarise how the sessions went, step by step and end to end.
+type SessionSummary struct {
+	Users     int                    `json:"users"`
+	Sessions  uint64                 `json:"sessions"`
+	Completed uint64                 `json:"completed"`
+	Failed    uint64                 `json:"failed"`
+	Retries   uint64                 `json:"retries"`
+	Polls     uint64                 `json:"polls"`
+	Steps     map[string]StepSummary `json:"steps"`
+}
+
+// Type used to summarise the latencies of a step of the sessions.
+type StepSummary struct {
+	Count     uint64            `json:"count"`
+	Failed    uint64            `json:"failed"`
+	Latency   LatencySummary    `json:"latency"`
+	Histogram []HistogramBucket `json:"histogram"`
+}
+
+// Type used to count the latencies between two bounds, the last bucket having no upper bound.
+type HistogramBucket struct {
+	From  time.Duration `json:"from"`
+	To    time.Duration `json:"to,omitempty"`
+	Count uint64        `json:"count"`
+}
+
+// Type used to configure the replay of a recording.
+type ReplayConfig struct {
+	Recording string  `json:"recording"`
+	Speed     float64 `json:"speed"`
+}
+
+// Type used to summarise how a replay compares with its recording. Lag is how late the
+// requests were sent compared with their scaled recorded offsets.
+type ReplaySummary struct {
+	Recording        string         `json:"recording"`
+	Speed            float64        `json:"speed"`
+	Recorded         uint64         `json:"recorded"`
+	Span             time.Duration  `json:"span"`
+	StatusMismatches uint64         `json:"status_mismatches"`
+	RecordedLatency  LatencySummary `json:"recorded_latency"`
+	Lag              LatencySummary `json:"lag"`
+}
+
+// Type used to configure a soak: the workload starts over until the duration is reached, and
+// the results are summarised every window. The latency trend threshold is the growth of the
+// p95 latency across the windows relative to the first one, the error one an absolute growth
+// of the error rate.
+type SoakConfig struct {
+	Duration     time.Duration `json:"duration"`
+	Window       time.Duration `json:"window"`
+	LatencyTrend float64       `json:"latency_trend"`
+	ErrorTrend   float64       `json:"error_trend"`
+}
+
+// Type used to tell how the p95 latency and the error rate grew across the windows of a soak,
+// and whether they grew beyond the configured thresholds. Trends are fitted phase by phase,
+// those of a whole window or soak being the steepest of its phases.
+type SoakTrends struct {
+	LatencyTrend        float64 `json:"latency_trend"`
+	ErrorTrend          float64 `json:"error_trend"`
+	LatencyTrendFlagged bool    `json:"latency_trend_flagged"`
+	ErrorTrendFlagged   bool    `json:"error_trend_flagged"`
+}
+
+// Type used to index the summary of a window of a soak, along with the trends up to it.
+type SoakWindow struct {
+	SchemaVersion int            `json:"schema_version"`
+	Workload      string         `json:"workload"`
+	RunID         string         `json:"run_id"`
+	Window        int            `json:"window"`
+	Cycle         int            `json:"cycle"`
+	Phases        []string       `json:"phases"`
+	StartTime     string         `json:"start_time"`
+	EndTime       string         `json:"end_time"`
+	Duration      time.Duration  `json:"duration"`
+	Requests      uint64         `json:"requests"`
+	Rate          float64        `json:"rate"`
+	Throughput    float64        `json:"throughput"`
+	Success       float64        `json:"success"`
+	ErrorRate     float64        `json:"error_rate"`
+	StatusCodes   map[string]int `json:"status_codes"`
+	Latency       LatencySummary `json:"latency"`
+	Errors        []string       `json:"errors"`
+	Breakdown     []SoakPhase    `json:"breakdown"`
+	SoakTrends
+}
+
+// Type used to summarise the requests of a phase within a window of a soak, along with
+// the trends of the phase up to it.
+type SoakPhase struct {
+	Phase       string         `json:"phase"`
+	Requests    uint64         `json:"requests"`
+	Throughput  float64        `json:"throughput"`
+	Success     float64        `json:"success"`
+	ErrorRate   float64        `json:"error_rate"`
+	StatusCodes map[string]int `json:"status_codes"`
+	Latency     LatencySummary `json:"latency"`
+	SoakTrends
+}
+
+// Type used to index the summary of a whole soak.
+type SoakSummary struct {
+	SchemaVersion int              `json:"schema_version"`
+	Workload      string           `json:"workload"`
+	RunID         string           `json:"run_id"`
+	Config        SoakConfig       `json:"config"`
+	StartTime     string           `json:"start_time"`
+	EndTime       string           `json:"end_time"`
+	Duration      time.Duration    `json:"duration"`
+	Cycles        int              `json:"cycles"`
+	Requests      uint64           `json:"requests"`
+	Windows       []SoakTrendPoint `json:"windows"`
+	PhaseTrends   []SoakPhaseTrend `json:"phase_trends"`
+	SoakTrends
+}
+
+// Type used to tell the trends of a phase over a whole soak.
+type SoakPhaseTrend struct {
+	Phase string `json:"phase"`
+	SoakTrends
+}
+
+// Type used to list the values the trends of a soak were fitted through, phase by phase.
+type SoakTrendPoint struct {
+	Window     int           `json:"window"`
+	Phase      string        `json:"phase"`
+	Requests   uint64        `json:"requests"`
+	P95Latency time.Duration `json:"p95_latency"`
+	ErrorRate  float64       `json:"error_rate"`
+}
+
+// Type used to request the changes between two update operations of an updater.
+type UpdateDiff struct {
+	Cur  string `json:"cur"`
+	Prev string `json:"prev"`
+}
+
+// Type used to index a single request into the samples index.
+type Sample struct {
+	RunID        string        `json:"run_id"`
+	Phase        string        `json:"phase"`
+	Seq          uint64        `json:"seq"`
+	Method       string        `json:"method"`
+	ManifestHash string        `json:"manifest_hash,omitempty"`
+	Status       uint16        `json:"status"`
+	Latency      time.Duration `json:"latency"`
+	Timestamp    string        `json:"timestamp"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// Type used to configure the availability workload: every endpoint is probed once per interval.
+type AvailabilityConfig struct {
+	Interval  time.Duration `json:"interval"`
+	Endpoints []string      `json:"endpoints"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Type used to summarise how available clair was while probed. Clair is counted down while
+// any of its endpoints is, the availability being the share of the probed time it was up.
+type AvailabilitySummary struct {
+	Start         string                          `json:"start"`
+	End           string                          `json:"end"`
+	Probed        time.Duration                   `json:"probed"`
+	Availability  float64                         `json:"availability"`
+	Downtime      time.Duration                   `json:"downtime"`
+	LongestOutage time.Duration                   `json:"longest_outage"`
+	Outages       []FailureWindow                 `json:"outages"`
+	Endpoints     map[string]EndpointAvailability `json:"endpoints"`
+}
+
+// Type used to summarise how available a single endpoint was while probed.
+type EndpointAvailability struct {
+	Probes        uint64          `json:"probes"`
+	Failures      uint64          `json:"failures"`
+	Availability  float64         `json:"availability"`
+	Downtime      time.Duration   `json:"downtime"`
+	LongestOutage time.Duration   `json:"longest_outage"`
+	Windows       []FailureWindow `json:"windows"`
+}
+
+// Type used to describe a stretch of time probes failed, from the first failed probe to the
+// next successful one. A window still open when probing stopped ends with the last probe.
+type FailureWindow struct {
+	Start       string         `json:"start"`
+	End         string         `json:"end"`
+	Duration    time.Duration  `json:"duration"`
+	Open        bool           `json:"open"`
+	Failures    uint64         `json:"failures"`
+	StatusCodes map[string]int `json:"status_codes"`
+	Endpoints   []string       `json:"endpoints,omitempty"`
 }
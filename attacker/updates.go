@@ -0,0 +1,71 @@
+package attacker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/quay/zlog"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Type used to decode the update operations clair lists for every updater.
+type updateOperation struct {
+	Ref     string    `json:"ref"`
+	Updater string    `json:"updater"`
+	Date    time.Time `json:"date"`
+}
+
+// DiscoverUpdateDiffs lists the update operations of the matcher once and pairs every operation
+// with the one preceding it for the same updater, the way the notifier walks them.
+// It returns the diffs to request and an error if the operations could not be listed.
+func DiscoverUpdateDiffs(ctx context.Context, conf *AttackConfig) ([]UpdateDiff, error) {
+	requests := generateVegetaRequests(GetUpdateOperationRequests(ctx, 1, conf.Host, ""))
+	targeter := vegeta.NewStaticTargeter(requests...)
+	if conf.Auth != nil {
+		targeter = authTargeter(targeter, conf.Auth.Token)
+	}
+	results, err := attack(ctx, targeter, Plan{Rate: 1, Hits: 1}, conf.Transport, "discover_update_operation")
+	if err != nil {
+		return nil, err
+	}
+	var res *vegeta.Result
+	for r := range results {
+		res = r
+	}
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	if res == nil || res.Code != http.StatusOK {
+		if res == nil {
+			return nil, fmt.Errorf("could not list update operations: no response")
+		}
+		return nil, fmt.Errorf("could not list update operations: %d %s", res.Code, res.Error)
+	}
+	var byUpdater map[string][]updateOperation
+	if err := json.Unmarshal(res.Body, &byUpdater); err != nil {
+		return nil, fmt.Errorf("could not decode update operations: %w", err)
+	}
+	updaters := make([]string, 0, len(byUpdater))
+	for u := range byUpdater {
+		updaters = append(updaters, u)
+	}
+	sort.Strings(updaters)
+	var diffs []UpdateDiff
+	for _, u := range updaters {
+		ops := byUpdater[u]
+		// Newest first
+		sort.SliceStable(ops, func(i, j int) bool { return ops[i].Date.After(ops[j].Date) })
+		for i := 0; i+1 < len(ops); i++ {
+			diffs = append(diffs, UpdateDiff{Cur: ops[i].Ref, Prev: ops[i+1].Ref})
+		}
+	}
+	zlog.Info(ctx).Int("updaters", len(updaters)).Int("diffs", len(diffs)).Msg("Discovered update operations")
+	if len(diffs) == 0 {
+		return nil, fmt.Errorf("no updater has two update operations to diff yet")
+	}
+	return diffs, nil
+}
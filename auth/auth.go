@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Constants
+const tokenFileRefresh = time.Minute
+
+// NewSource creates the token source for the configured mode.
+// It returns the source and an error if the configuration is incomplete or the keys are invalid.
+func NewSource(c Config) (Source, error) {
+	switch c.Mode {
+	case ModeNone:
+		return noneSource{}, nil
+	case ModePSK, "":
+		if c.PSK == "" {
+			return nil, fmt.Errorf("psk authentication needs a PSK")
+		}
+		signer, err := NewPSKSigner(c.PSK)
+		if err != nil {
+			return nil, err
+		}
+		return newMintingSource(signer, c), nil
+	case ModeKey:
+		if c.KeyFile == "" {
+			return nil, fmt.Errorf("key authentication needs a signing key")
+		}
+		signer, err := NewKeySigner(c.KeyFile, c.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		return newMintingSource(signer, c), nil
+	case ModeTokenFile:
+		if c.TokenFile == "" {
+			return nil, fmt.Errorf("token-file authentication needs a token file")
+		}
+		s := &fileSource{path: c.TokenFile}
+		if _, err := s.Token(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown authentication mode %q: must be one among %s, %s, %s or %s", c.Mode, ModeNone, ModePSK, ModeTokenFile, ModeKey)
+	}
+}
+
+// NewPSKSigner creates an HS256 signer from a base64 encoded PSK.
+// It returns the signer and an error if the key could not be decoded.
+func NewPSKSigner(key string) (jose.Signer, error) {
+	decKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode PSK: %w", err)
+	}
+	return jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.HS256,
+		Key:       decKey,
+	}, nil)
+}
+
+// NewKeySigner creates a signer from a PEM encoded RSA or EC private key,
+// setting the kid header when a key ID is given.
+// It returns the signer and an error if the key could not be loaded.
+func NewKeySigner(path, keyID string) (jose.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key: %w", err)
+	}
+	var alg jose.SignatureAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		alg = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg = jose.ES256
+		case elliptic.P384():
+			alg = jose.ES384
+		case elliptic.P521():
+			alg = jose.ES512
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %s", k.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return jose.NewSigner(jose.SigningKey{
+		Algorithm: alg,
+		Key:       jose.JSONWebKey{Key: key, KeyID: keyID},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+}
+
+// Mint signs a token with the configured claims, valid from now on.
+// It returns the token string and an error if any during the execution.
+func Mint(s jose.Signer, c Config, now time.Time) (string, error) {
+	return mint(s, c, now, "")
+}
+
+// mint signs a token with the configured claims and the given token ID, valid from now on.
+// It returns the token string and an error if any during the execution.
+func mint(s jose.Signer, c Config, now time.Time, id string) (string, error) {
+	issuer := c.Issuer
+	if issuer == "" {
+		issuer = DefaultIssuer
+	}
+	validity := c.Validity
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	return jwt.Signed(s).Claims(&jwt.Claims{
+		Issuer:    issuer,
+		Audience:  jwt.Audience(c.Audience),
+		Subject:   c.Subject,
+		Expiry:    jwt.NewNumericDate(now.Add(validity)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ID:        id,
+	}).CompactSerialize()
+}
+
+// Type used when requests go unauthenticated.
+type noneSource struct{}
+
+// Token returns an empty token.
+func (noneSource) Token() (string, error) { return "", nil }
+
+// Type used to mint tokens and refresh them before they expire.
+type mintingSource struct {
+	mu      sync.Mutex
+	signer  jose.Signer
+	conf    Config
+	id      string
+	token   string
+	refresh time.Time
+}
+
+// newMintingSource creates a source minting tokens with the given signer.
+// It returns the source.
+func newMintingSource(s jose.Signer, c Config) *mintingSource {
+	if c.Validity <= 0 {
+		c.Validity = DefaultValidity
+	}
+	return &mintingSource{signer: s, conf: c}
+}
+
+// Token returns the current token, minting a new one once 80% of its validity has passed.
+func (m *mintingSource) Token() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.token != "" && now.Before(m.refresh) {
+		return m.token, nil
+	}
+	tok, err := mint(m.signer, m.conf, now, m.id)
+	if err != nil {
+		return "", fmt.Errorf("could not mint token: %w", err)
+	}
+	m.token = tok
+	m.refresh = now.Add(m.conf.Validity * 4 / 5)
+	return m.token, nil
+}
+
+// Type used to read a pre-issued token, re-reading the file so rotated tokens are picked up.
+type fileSource struct {
+	mu    sync.Mutex
+	path  string
+	token string
+	read  time.Time
+}
+
+// Token returns the token held in the file.
+func (f *fileSource) Token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.token != "" && time.Since(f.read) < tokenFileRefresh {
+		return f.token, nil
+	}
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("could not read token file: %w", err)
+	}
+	tok := strings.TrimSpace(string(b))
+	if tok == "" {
+		return "", fmt.Errorf("token file %s is empty", f.path)
+	}
+	f.token = tok
+	f.read = time.Now()
+	return f.token, nil
+}
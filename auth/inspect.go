@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Decode splits a compact token into its header and claims, without checking the signature.
+// It returns the header, the claims and an error if the token is malformed.
+func Decode(token string) (header, claims map[string]interface{}, err error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("token must have 3 dot separated parts, got %d", len(parts))
+	}
+	if header, err = decodeSegment(parts[0]); err != nil {
+		return nil, nil, fmt.Errorf("could not decode header: %w", err)
+	}
+	if claims, err = decodeSegment(parts[1]); err != nil {
+		return nil, nil, fmt.Errorf("could not decode claims: %w", err)
+	}
+	return header, claims, nil
+}
+
+// decodeSegment decodes a base64url JSON object of a compact token.
+// It returns the object and an error if the segment is not valid.
+func decodeSegment(s string) (map[string]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Verify checks a token the way clair does for PSK authentication: the signature against the PSK,
+// the validity window at the given time and the issuer, audience and subject of the expected claims
+// when they are set.
+// It returns every problem found, empty when the token would be accepted, and an error if the token
+// or the PSK could not be parsed at all.
+func Verify(token, psk string, expected Config, leeway time.Duration, now time.Time) ([]string, error) {
+	tok, err := jwt.ParseSigned(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse token: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(psk)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode PSK: %w", err)
+	}
+	var problems []string
+	var claims jwt.Claims
+	if err := tok.Claims(key, &claims); err != nil {
+		problems = append(problems, fmt.Sprintf("signature does not verify with the PSK: %v", err))
+		if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+			return nil, fmt.Errorf("could not decode claims: %w", err)
+		}
+	}
+	if claims.Expiry != nil && now.Add(-leeway).After(claims.Expiry.Time()) {
+		problems = append(problems, fmt.Sprintf("token expired at %s (exp)", claims.Expiry.Time().UTC().Format(time.RFC3339)))
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(claims.NotBefore.Time()) {
+		problems = append(problems, fmt.Sprintf("token not valid before %s (nbf)", claims.NotBefore.Time().UTC().Format(time.RFC3339)))
+	}
+	if claims.IssuedAt != nil && now.Add(leeway).Before(claims.IssuedAt.Time()) {
+		problems = append(problems, fmt.Sprintf("token issued in the future at %s (iat)", claims.IssuedAt.Time().UTC().Format(time.RFC3339)))
+	}
+	if expected.Issuer != "" && claims.Issuer != expected.Issuer {
+		problems = append(problems, fmt.Sprintf("issuer is %q, expected %q (iss)", claims.Issuer, expected.Issuer))
+	}
+	for _, a := range expected.Audience {
+		if !claims.Audience.Contains(a) {
+			problems = append(problems, fmt.Sprintf("audience %v does not contain %q (aud)", []string(claims.Audience), a))
+		}
+	}
+	if expected.Subject != "" && claims.Subject != expected.Subject {
+		problems = append(problems, fmt.Sprintf("subject is %q, expected %q (sub)", claims.Subject, expected.Subject))
+	}
+	return problems, nil
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Type used to hand out the token of every single request, following the scope and
+// the faults of the configuration.
+type Issuer struct {
+	next uint64
+	Source
+	conf     Config
+	signer   jose.Signer
+	users    []*mintingSource
+	mu       sync.Mutex
+	injected map[string]uint64
+}
+
+// NewIssuer creates the token issuer for the configured mode, scope and faults.
+// It returns the issuer and an error if the configuration is incomplete or cannot be honoured by the mode.
+func NewIssuer(c Config) (*Issuer, error) {
+	if c.Scope == "" {
+		c.Scope = ScopeRun
+	}
+	if err := c.Faults.validate(); err != nil {
+		return nil, err
+	}
+	src, err := NewSource(c)
+	if err != nil {
+		return nil, err
+	}
+	i := &Issuer{Source: src, conf: c, injected: map[string]uint64{}}
+	if m, ok := src.(*mintingSource); ok {
+		i.signer = m.signer
+		i.conf.Validity = m.conf.Validity
+	}
+	switch c.Scope {
+	case ScopeRun:
+	case ScopeRequest, ScopeUser:
+		if i.signer == nil {
+			return nil, fmt.Errorf("%s token scope needs tokens minted with the psk or key modes", c.Scope)
+		}
+	default:
+		return nil, fmt.Errorf("unknown token scope %q: must be one among %s, %s or %s", c.Scope, ScopeRun, ScopeRequest, ScopeUser)
+	}
+	if c.Scope == ScopeUser {
+		if c.Users <= 0 {
+			return nil, fmt.Errorf("user token scope needs a positive number of users")
+		}
+		for u := 0; u < c.Users; u++ {
+			m := newMintingSource(i.signer, i.conf)
+			m.id = fmt.Sprintf("user-%d", u)
+			i.users = append(i.users, m)
+		}
+	}
+	if c.Faults.Expired > 0 && i.signer == nil {
+		return nil, fmt.Errorf("expired tokens can only be minted with the psk or key modes")
+	}
+	if c.Faults.BadSignature > 0 && c.Mode == ModeNone {
+		return nil, fmt.Errorf("badly signed tokens need a token to tamper with")
+	}
+	return i, nil
+}
+
+// validate makes sure the fault fractions are sensible.
+// It returns an error if any fraction is out of range or they add up to more than every request.
+func (f Faults) validate() error {
+	for _, v := range []float64{f.Expired, f.BadSignature, f.Missing} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("token fault fractions must be between 0 and 1")
+		}
+	}
+	if f.Expired+f.BadSignature+f.Missing > 1 {
+		return fmt.Errorf("token fault fractions add up to more than 1")
+	}
+	return nil
+}
+
+// Scope returns the scope tokens are issued with.
+func (i *Issuer) Scope() string {
+	return i.conf.Scope
+}
+
+// Next returns the token of the next request, which is broken on purpose for the
+// configured fraction of requests.
+// It returns the token, empty when the request goes unauthenticated, and an error if it could not be minted.
+func (i *Issuer) Next() (string, error) {
+	var fault string
+	if f := i.conf.Faults; f.Expired+f.BadSignature+f.Missing > 0 {
+		r := mrand.Float64()
+		switch {
+		case r < f.Expired:
+			fault = FaultExpired
+		case r < f.Expired+f.BadSignature:
+			fault = FaultBadSignature
+		case r < f.Expired+f.BadSignature+f.Missing:
+			fault = FaultMissing
+		}
+	}
+	if fault != "" {
+		i.mu.Lock()
+		i.injected[fault]++
+		i.mu.Unlock()
+	}
+
+	switch fault {
+	case FaultMissing:
+		return "", nil
+	case FaultExpired:
+		// Valid for the configured period, which ended before now
+		return mint(i.signer, i.conf, time.Now().Add(-2*i.conf.Validity), uniqueID())
+	}
+	var tok string
+	var err error
+	switch i.conf.Scope {
+	case ScopeRequest:
+		tok, err = mint(i.signer, i.conf, time.Now(), uniqueID())
+	case ScopeUser:
+		tok, err = i.users[atomic.AddUint64(&i.next, 1)%uint64(len(i.users))].Token()
+	default:
+		tok, err = i.Token()
+	}
+	if err != nil || fault != FaultBadSignature {
+		return tok, err
+	}
+	return tamper(tok), nil
+}
+
+// Config returns the configuration tokens are issued with, so that other processes can issue them alike.
+func (i *Issuer) Config() Config {
+	return i.conf
+}
+
+// AddInjected counts broken tokens handed out elsewhere, such as by the workers of a distributed run.
+func (i *Issuer) AddInjected(injected map[string]uint64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for k, v := range injected {
+		i.injected[k] += v
+	}
+}
+
+// Injected returns how many broken tokens of every kind were handed out so far.
+func (i *Issuer) Injected() map[string]uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	out := make(map[string]uint64, len(i.injected))
+	for k, v := range i.injected {
+		out[k] = v
+	}
+	return out
+}
+
+// uniqueID returns a random token ID, so that tokens minted within the same second differ.
+func uniqueID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tamper flips the bits of the signature of a compact token, keeping its header and claims.
+// It returns the token with a signature that no longer verifies.
+func tamper(tok string) string {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return tok + "x"
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) == 0 {
+		return tok + "x"
+	}
+	for k := range sig {
+		sig[k] ^= 0xff
+	}
+	parts[2] = base64.RawURLEncoding.EncodeToString(sig)
+	return strings.Join(parts, ".")
+}
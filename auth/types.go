@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"time"
+)
+
+// Authentication modes
+const (
+	ModeNone      = "none"
+	ModePSK       = "psk"
+	ModeTokenFile = "token-file"
+	ModeKey       = "key"
+)
+
+// Token scopes
+const (
+	ScopeRun     = "run"
+	ScopeRequest = "request"
+	ScopeUser    = "user"
+)
+
+// Kinds of injected token faults
+const (
+	FaultExpired      = "expired"
+	FaultBadSignature = "bad_signature"
+	FaultMissing      = "missing"
+)
+
+// Constants
+const (
+	DefaultIssuer   = "clairctl"
+	DefaultValidity = time.Hour * 24 * 7
+)
+
+// Type used to configure how requests authenticate against clair.
+type Config struct {
+	Mode      string        `json:"mode"`
+	PSK       string        `json:"-"`
+	Issuer    string        `json:"issuer,omitempty"`
+	Audience  []string      `json:"audience,omitempty"`
+	Subject   string        `json:"subject,omitempty"`
+	Validity  time.Duration `json:"validity,omitempty"`
+	TokenFile string        `json:"token_file,omitempty"`
+	KeyFile   string        `json:"key_file,omitempty"`
+	KeyID     string        `json:"key_id,omitempty"`
+	Scope     string        `json:"scope,omitempty"`
+	Users     int           `json:"users,omitempty"`
+	Faults    Faults        `json:"faults"`
+}
+
+// Type used to set the fraction of requests sent with a broken token.
+type Faults struct {
+	Expired      float64 `json:"expired"`
+	BadSignature float64 `json:"bad_signature"`
+	Missing      float64 `json:"missing"`
+}
+
+// Source hands out bearer tokens.
+type Source interface {
+	// Token returns a valid token, or an empty string when requests go unauthenticated.
+	Token() (string, error)
+}
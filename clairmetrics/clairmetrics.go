@@ -0,0 +1,304 @@
+package clairmetrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+// Constants
+const scrapeTimeout = 30 * time.Second
+
+// Scrape fetches the prometheus metrics exposed on clair's introspection endpoint.
+// It returns a snapshot of every series and an error if any during the execution.
+func Scrape(ctx context.Context, url string) (*Snapshot, error) {
+	zlog.Debug(ctx).Str("url", url).Msg("scraping clair metrics")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: scrapeTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not scrape clair metrics: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status while scraping clair metrics: %s", res.Status)
+	}
+	return Parse(res.Body)
+}
+
+// Parse reads metrics in the prometheus text exposition format.
+// It returns a snapshot of every series and an error if any during the execution.
+func Parse(r io.Reader) (*Snapshot, error) {
+	snap := &Snapshot{
+		Time:   time.Now(),
+		Types:  make(map[string]string),
+		Series: make(map[string]Series),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				snap.Types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		s, err := parseSeries(line)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(s.Value) {
+			continue
+		}
+		snap.Series[seriesKey(s.Name, s.Labels)] = s
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read clair metrics: %w", err)
+	}
+	return snap, nil
+}
+
+// parseSeries parses a single sample line of the exposition format.
+// It returns the parsed series and an error if the line is malformed.
+func parseSeries(line string) (Series, error) {
+	var s Series
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return s, fmt.Errorf("malformed metrics line %q", line)
+	}
+	s.Name = line[:i]
+	rest := line[i:]
+	if rest[0] == '{' {
+		labels, n, err := parseLabels(rest)
+		if err != nil {
+			return s, fmt.Errorf("malformed metrics line %q: %w", line, err)
+		}
+		s.Labels = labels
+		rest = rest[n:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("malformed metrics line %q: missing value", line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("malformed metrics line %q: %w", line, err)
+	}
+	s.Value = v
+	return s, nil
+}
+
+// parseLabels parses a label set starting at the opening brace.
+// It returns the labels, the number of bytes consumed and an error if any.
+func parseLabels(in string) (map[string]string, int, error) {
+	labels := make(map[string]string)
+	i := 1
+	for {
+		for i < len(in) && (in[i] == ' ' || in[i] == ',') {
+			i++
+		}
+		if i >= len(in) {
+			return nil, 0, fmt.Errorf("unterminated label set")
+		}
+		if in[i] == '}' {
+			return labels, i + 1, nil
+		}
+		eq := strings.IndexByte(in[i:], '=')
+		if eq < 0 || i+eq+1 >= len(in) || in[i+eq+1] != '"' {
+			return nil, 0, fmt.Errorf("malformed label")
+		}
+		name := strings.TrimSpace(in[i : i+eq])
+		i += eq + 2
+		var b strings.Builder
+		for ; i < len(in) && in[i] != '"'; i++ {
+			if in[i] == '\\' && i+1 < len(in) {
+				i++
+				switch in[i] {
+				case 'n':
+					b.WriteByte('\n')
+				default:
+					b.WriteByte(in[i])
+				}
+				continue
+			}
+			b.WriteByte(in[i])
+		}
+		if i >= len(in) {
+			return nil, 0, fmt.Errorf("unterminated label value")
+		}
+		labels[name] = b.String()
+		i++
+	}
+}
+
+// seriesKey generates a stable identifier for a series.
+// It returns a string made of the name and the sorted labels.
+func seriesKey(name string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range names {
+		b.WriteString("," + k + "=" + labels[k])
+	}
+	return b.String()
+}
+
+// familyOf looks up the metric family a series belongs to.
+// It returns the family name and its type.
+func familyOf(name string, types map[string]string) (string, string) {
+	if t, ok := types[name]; ok {
+		return name, t
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if base := strings.TrimSuffix(name, suffix); base != name {
+			if t, ok := types[base]; ok {
+				return base, t
+			}
+		}
+	}
+	return name, "untyped"
+}
+
+// Type used to accumulate the buckets of a single histogram.
+type histogram struct {
+	name    string
+	labels  map[string]string
+	buckets []bucket
+	sum     float64
+	count   float64
+}
+
+// Type used to store a single cumulative bucket.
+type bucket struct {
+	le    float64
+	count float64
+}
+
+// Diff compares two snapshots taken around a phase.
+// It returns the counters that moved and the histograms that recorded observations.
+func Diff(before, after *Snapshot) *Delta {
+	delta := &Delta{
+		Start:      before.Time,
+		End:        after.Time,
+		Counters:   []CounterDelta{},
+		Histograms: []HistogramSummary{},
+	}
+	histograms := make(map[string]*histogram)
+	for key, s := range after.Series {
+		d := s.Value
+		if prev, ok := before.Series[key]; ok && prev.Value <= s.Value {
+			d = s.Value - prev.Value
+		}
+		family, kind := familyOf(s.Name, after.Types)
+		switch {
+		case kind == "counter", kind == "summary" && family != s.Name:
+			if d != 0 {
+				delta.Counters = append(delta.Counters, CounterDelta{Name: s.Name, Labels: s.Labels, Delta: d})
+			}
+		case kind == "histogram":
+			labels := make(map[string]string, len(s.Labels))
+			var le string
+			for k, v := range s.Labels {
+				if k == "le" {
+					le = v
+					continue
+				}
+				labels[k] = v
+			}
+			hkey := seriesKey(family, labels)
+			h, ok := histograms[hkey]
+			if !ok {
+				h = &histogram{name: family, labels: labels}
+				histograms[hkey] = h
+			}
+			switch s.Name {
+			case family + "_bucket":
+				bound, err := strconv.ParseFloat(le, 64)
+				if err != nil {
+					continue
+				}
+				h.buckets = append(h.buckets, bucket{le: bound, count: d})
+			case family + "_sum":
+				h.sum = d
+			case family + "_count":
+				h.count = d
+			}
+		}
+	}
+	for _, h := range histograms {
+		if h.count == 0 {
+			continue
+		}
+		sort.Slice(h.buckets, func(i, j int) bool { return h.buckets[i].le < h.buckets[j].le })
+		delta.Histograms = append(delta.Histograms, HistogramSummary{
+			Name:   h.name,
+			Labels: h.labels,
+			Count:  h.count,
+			Sum:    h.sum,
+			Mean:   h.sum / h.count,
+			P50:    bucketQuantile(0.50, h.buckets),
+			P90:    bucketQuantile(0.90, h.buckets),
+			P95:    bucketQuantile(0.95, h.buckets),
+			P99:    bucketQuantile(0.99, h.buckets),
+		})
+	}
+	sort.Slice(delta.Counters, func(i, j int) bool {
+		return seriesKey(delta.Counters[i].Name, delta.Counters[i].Labels) < seriesKey(delta.Counters[j].Name, delta.Counters[j].Labels)
+	})
+	sort.Slice(delta.Histograms, func(i, j int) bool {
+		return seriesKey(delta.Histograms[i].Name, delta.Histograms[i].Labels) < seriesKey(delta.Histograms[j].Name, delta.Histograms[j].Labels)
+	})
+	return delta
+}
+
+// bucketQuantile estimates a quantile by linear interpolation within cumulative buckets
+// sorted by their upper bound, the same way prometheus' histogram_quantile does.
+// It returns zero when the quantile cannot be estimated.
+func bucketQuantile(q float64, buckets []bucket) float64 {
+	if len(buckets) < 2 || !math.IsInf(buckets[len(buckets)-1].le, 1) {
+		return 0
+	}
+	total := buckets[len(buckets)-1].count
+	if total == 0 {
+		return 0
+	}
+	rank := q * total
+	b := sort.Search(len(buckets)-1, func(i int) bool { return buckets[i].count >= rank })
+	if b == len(buckets)-1 {
+		return buckets[len(buckets)-2].le
+	}
+	if b == 0 && buckets[0].le <= 0 {
+		return buckets[0].le
+	}
+	start, end, count := 0.0, buckets[b].le, buckets[b].count
+	if b > 0 {
+		start = buckets[b-1].le
+		count -= buckets[b-1].count
+		rank -= buckets[b-1].count
+	}
+	if count == 0 {
+		return end
+	}
+	return start + (end-start)*(rank/count)
+}
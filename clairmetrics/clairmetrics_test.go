@@ -0,0 +1,195 @@
+package clairmetrics
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		series map[string]Series
+		types  map[string]string
+	}{
+		{
+			name: "plain",
+			in:   "# HELP up Whether it is up\n# TYPE up gauge\nup 1\n",
+			series: map[string]Series{
+				"up": {Name: "up", Value: 1},
+			},
+			types: map[string]string{"up": "gauge"},
+		},
+		{
+			name: "labels",
+			in:   `http_requests_total{method="GET", code="200",} 1027 1395066363000`,
+			series: map[string]Series{
+				"http_requests_total,code=200,method=GET": {Name: "http_requests_total", Labels: map[string]string{"method": "GET", "code": "200"}, Value: 1027},
+			},
+		},
+		{
+			name: "escaped label values",
+			in:   `msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9`,
+			series: map[string]Series{
+				"msdos_file_access_time_seconds,error=Cannot find file:\n\"FILE.TXT\",path=C:\\DIR\\FILE.TXT": {
+					Name:   "msdos_file_access_time_seconds",
+					Labels: map[string]string{"path": `C:\DIR\FILE.TXT`, "error": "Cannot find file:\n\"FILE.TXT\""},
+					Value:  1.458255915e9,
+				},
+			},
+		},
+		{
+			name: "braces and spaces inside label values",
+			in:   `grpc_calls{route="/x {y} z"} 2`,
+			series: map[string]Series{
+				"grpc_calls,route=/x {y} z": {Name: "grpc_calls", Labels: map[string]string{"route": "/x {y} z"}, Value: 2},
+			},
+		},
+		{
+			name: "infinite bound and NaN skipped",
+			in:   "latency_bucket{le=\"+Inf\"} 4\nlatency_sum NaN\n",
+			series: map[string]Series{
+				"latency_bucket,le=+Inf": {Name: "latency_bucket", Labels: map[string]string{"le": "+Inf"}, Value: 4},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			snap, err := Parse(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(snap.Series, tc.series) {
+				t.Errorf("series: got %#v, want %#v", snap.Series, tc.series)
+			}
+			if tc.types == nil {
+				tc.types = map[string]string{}
+			}
+			if !reflect.DeepEqual(snap.Types, tc.types) {
+				t.Errorf("types: got %v, want %v", snap.Types, tc.types)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, in := range []string{
+		`{le="1"} 1`,
+		`up`,
+		`up{le="1"`,
+		`up{le="1} 1`,
+		`up{le=1} 1`,
+		`up one`,
+	} {
+		if _, err := Parse(strings.NewReader(in)); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestBucketQuantile(t *testing.T) {
+	inf := math.Inf(1)
+	buckets := []bucket{{0.1, 10}, {0.5, 30}, {inf, 40}}
+	tests := []struct {
+		name    string
+		q       float64
+		buckets []bucket
+		want    float64
+	}{
+		{name: "no buckets", q: 0.5, want: 0},
+		{name: "no +Inf bucket", q: 0.5, buckets: []bucket{{0.1, 10}, {0.5, 30}}, want: 0},
+		{name: "only +Inf bucket", q: 0.5, buckets: []bucket{{inf, 10}}, want: 0},
+		{name: "empty", q: 0.5, buckets: []bucket{{0.1, 0}, {inf, 0}}, want: 0},
+		{name: "first bucket", q: 0.1, buckets: buckets, want: 0.04},
+		{name: "interpolated", q: 0.5, buckets: buckets, want: 0.3},
+		{name: "in +Inf bucket", q: 0.9, buckets: buckets, want: 0.5},
+		{name: "empty bucket", q: 0.5, buckets: []bucket{{0.1, 20}, {0.5, 20}, {inf, 40}}, want: 0.1},
+		{name: "negative first bound", q: 0.1, buckets: []bucket{{-1, 10}, {inf, 10}}, want: -1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := bucketQuantile(tc.q, tc.buckets); math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	parse := func(in string) *Snapshot {
+		t.Helper()
+		snap, err := Parse(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snap
+	}
+	types := "# TYPE requests_total counter\n# TYPE restarts_total counter\n# TYPE idle_total counter\n# TYPE latency_seconds histogram\n# TYPE quiet_seconds histogram\n"
+	before := parse(types + `requests_total{code="200"} 10
+restarts_total 50
+idle_total 3
+latency_seconds_bucket{route="a",le="0.1"} 5
+latency_seconds_bucket{route="a",le="0.5"} 5
+latency_seconds_bucket{route="a",le="+Inf"} 5
+latency_seconds_sum{route="a"} 0.2
+latency_seconds_count{route="a"} 5
+quiet_seconds_bucket{le="1"} 2
+quiet_seconds_bucket{le="+Inf"} 2
+quiet_seconds_sum 1
+quiet_seconds_count 2
+`)
+	before.Time = time.Unix(0, 0)
+	after := parse(types + `requests_total{code="200"} 25
+requests_total{code="500"} 2
+restarts_total 7
+idle_total 3
+latency_seconds_bucket{route="a",le="0.1"} 15
+latency_seconds_bucket{route="a",le="0.5"} 35
+latency_seconds_bucket{route="a",le="+Inf"} 45
+latency_seconds_sum{route="a"} 8.2
+latency_seconds_count{route="a"} 45
+quiet_seconds_bucket{le="1"} 2
+quiet_seconds_bucket{le="+Inf"} 2
+quiet_seconds_sum 1
+quiet_seconds_count 2
+`)
+	after.Time = time.Unix(60, 0)
+
+	d := Diff(before, after)
+	wantCounters := []CounterDelta{
+		{Name: "requests_total", Labels: map[string]string{"code": "200"}, Delta: 15},
+		{Name: "requests_total", Labels: map[string]string{"code": "500"}, Delta: 2},
+		// A counter going down was reset, everything it counts happened since
+		{Name: "restarts_total", Delta: 7},
+	}
+	if !reflect.DeepEqual(d.Counters, wantCounters) {
+		t.Errorf("counters: got %+v, want %+v", d.Counters, wantCounters)
+	}
+	if len(d.Histograms) != 1 {
+		t.Fatalf("histograms: got %+v, want only latency_seconds", d.Histograms)
+	}
+	h := d.Histograms[0]
+	if h.Name != "latency_seconds" || !reflect.DeepEqual(h.Labels, map[string]string{"route": "a"}) {
+		t.Errorf("histogram: got %s %v", h.Name, h.Labels)
+	}
+	if h.Count != 40 || math.Abs(h.Sum-8) > 1e-9 || math.Abs(h.Mean-0.2) > 1e-9 {
+		t.Errorf("histogram: got count %v sum %v mean %v", h.Count, h.Sum, h.Mean)
+	}
+	if math.Abs(h.P50-0.3) > 1e-9 || h.P95 != 0.5 {
+		t.Errorf("histogram: got p50 %v p95 %v", h.P50, h.P95)
+	}
+	if !d.Start.Equal(before.Time) || !d.End.Equal(after.Time) {
+		t.Errorf("got start %v end %v", d.Start, d.End)
+	}
+}
+
+func TestDiffEmpty(t *testing.T) {
+	empty := &Snapshot{Types: map[string]string{}, Series: map[string]Series{}}
+	d := Diff(empty, empty)
+	if d.Counters == nil || d.Histograms == nil || len(d.Counters) != 0 || len(d.Histograms) != 0 {
+		t.Errorf("got %+v, want empty non nil lists", d)
+	}
+}
@@ -0,0 +1,47 @@
+package clairmetrics
+
+import (
+	"time"
+)
+
+// Type used to hold a single scrape of the introspection endpoint.
+type Snapshot struct {
+	Time   time.Time
+	Types  map[string]string
+	Series map[string]Series
+}
+
+// Type used to hold a single sample parsed from the exposition format.
+type Series struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Type used to index the server side breakdown of a phase.
+type Delta struct {
+	Start      time.Time          `json:"start"`
+	End        time.Time          `json:"end"`
+	Counters   []CounterDelta     `json:"counters"`
+	Histograms []HistogramSummary `json:"histograms"`
+}
+
+// Type used to store the increase of a counter over a phase.
+type CounterDelta struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Delta  float64           `json:"delta"`
+}
+
+// Type used to store the observations made by a histogram over a phase.
+type HistogramSummary struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Count  float64           `json:"count"`
+	Sum    float64           `json:"sum"`
+	Mean   float64           `json:"mean"`
+	P50    float64           `json:"p50"`
+	P90    float64           `json:"p90"`
+	P95    float64           `json:"p95"`
+	P99    float64           `json:"p99"`
+}
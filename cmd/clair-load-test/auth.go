@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/quay/clair-load-test/auth"
+	"github.com/urfave/cli/v2"
+)
+
+// claimFlags are the options shaping the claims of minted tokens.
+var claimFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "token-issuer",
+		Usage:   "--token-issuer quay",
+		Value:   auth.DefaultIssuer,
+		EnvVars: []string{"CLAIR_TEST_TOKEN_ISSUER"},
+	},
+	&cli.StringFlag{
+		Name:    "token-audience",
+		Usage:   "--token-audience clair,clair-indexer",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_TOKEN_AUDIENCE"},
+	},
+	&cli.StringFlag{
+		Name:    "token-subject",
+		Usage:   "--token-subject quay",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_TOKEN_SUBJECT"},
+	},
+	&cli.DurationFlag{
+		Name:    "token-validity",
+		Usage:   "--token-validity 5m",
+		Value:   auth.DefaultValidity,
+		EnvVars: []string{"CLAIR_TEST_TOKEN_VALIDITY"},
+	},
+}
+
+// pskFileFlag points at a file holding the PSK, such as a mounted Kubernetes secret.
+var pskFileFlag = &cli.StringFlag{
+	Name:    "psk-file",
+	Usage:   "--psk-file /var/run/secrets/clair/psk",
+	Value:   "",
+	EnvVars: []string{"CLAIR_TEST_PSK_FILE"},
+}
+
+// authFlags are the options selecting how requests authenticate against clair.
+var authFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:    "auth",
+		Usage:   "--auth [none, psk, token-file, key]",
+		Value:   auth.ModePSK,
+		EnvVars: []string{"CLAIR_TEST_AUTH"},
+		Action: func(ctx *cli.Context, v string) error {
+			switch v {
+			case auth.ModeNone, auth.ModePSK, auth.ModeTokenFile, auth.ModeKey:
+				return nil
+			}
+			return fmt.Errorf("Invalid auth value. Must be one among: %v", []string{auth.ModeNone, auth.ModePSK, auth.ModeTokenFile, auth.ModeKey})
+		},
+	},
+	&cli.StringFlag{
+		Name:    "psk",
+		Usage:   "--psk secretkey",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_PSK"},
+	},
+	pskFileFlag,
+	&cli.StringFlag{
+		Name:    "token-file",
+		Usage:   "--token-file /var/run/secrets/clair/token",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_TOKEN_FILE"},
+	},
+	&cli.StringFlag{
+		Name:    "signing-key",
+		Usage:   "--signing-key /var/run/secrets/clair/key.pem",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_SIGNING_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "key-id",
+		Usage:   "--key-id 7d5f0c3e",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_KEY_ID"},
+	},
+	&cli.StringFlag{
+		Name:    "token-scope",
+		Usage:   "--token-scope [run, request, user]",
+		Value:   auth.ScopeRun,
+		EnvVars: []string{"CLAIR_TEST_TOKEN_SCOPE"},
+		Action: func(ctx *cli.Context, v string) error {
+			switch v {
+			case auth.ScopeRun, auth.ScopeRequest, auth.ScopeUser:
+				return nil
+			}
+			return fmt.Errorf("Invalid token-scope value. Must be one among: %v", []string{auth.ScopeRun, auth.ScopeRequest, auth.ScopeUser})
+		},
+	},
+	&cli.IntFlag{
+		Name:    "token-users",
+		Usage:   "--token-users 50",
+		Value:   10,
+		EnvVars: []string{"CLAIR_TEST_TOKEN_USERS"},
+	},
+	&cli.Float64Flag{
+		Name:    "expired-tokens",
+		Usage:   "--expired-tokens 0.05",
+		Value:   0,
+		EnvVars: []string{"CLAIR_TEST_EXPIRED_TOKENS"},
+	},
+	&cli.Float64Flag{
+		Name:    "bad-signature-tokens",
+		Usage:   "--bad-signature-tokens 0.05",
+		Value:   0,
+		EnvVars: []string{"CLAIR_TEST_BAD_SIGNATURE_TOKENS"},
+	},
+	&cli.Float64Flag{
+		Name:    "missing-tokens",
+		Usage:   "--missing-tokens 0.05",
+		Value:   0,
+		EnvVars: []string{"CLAIR_TEST_MISSING_TOKENS"},
+	},
+}, claimFlags...)
+
+// NewAuthConfig creates the authentication configuration from CLI options.
+// It returns the configuration and an error if the PSK file could not be read.
+func NewAuthConfig(c *cli.Context) (auth.Config, error) {
+	psk, err := secretOption(c, "psk", "psk-file")
+	if err != nil {
+		return auth.Config{}, err
+	}
+	return auth.Config{
+		Mode:      c.String("auth"),
+		PSK:       psk,
+		Issuer:    c.String("token-issuer"),
+		Audience:  splitList(c.String("token-audience")),
+		Subject:   c.String("token-subject"),
+		Validity:  c.Duration("token-validity"),
+		TokenFile: c.String("token-file"),
+		KeyFile:   c.String("signing-key"),
+		KeyID:     c.String("key-id"),
+		Scope:     c.String("token-scope"),
+		Users:     c.Int("token-users"),
+		Faults: auth.Faults{
+			Expired:      c.Float64("expired-tokens"),
+			BadSignature: c.Float64("bad-signature-tokens"),
+			Missing:      c.Float64("missing-tokens"),
+		},
+	}, nil
+}
+
+// secretOption reads a secret from its file option when set, falling back to the plain option.
+// Files are read as mounted Kubernetes secrets, trailing newlines and spaces are dropped.
+// It returns the secret and an error if the file could not be read.
+func secretOption(c *cli.Context, name, fileName string) (string, error) {
+	path := c.String(fileName)
+	if path == "" {
+		return c.String(name), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read --%s: %w", fileName, err)
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("--%s %s is empty", fileName, path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// AvailabilityCmd handles the availability CLI.
+var AvailabilityCmd = &cli.Command{
+	Name:        "availability",
+	Description: "Probes clair's endpoints at a steady low rate, e.g. during an upgrade, and measures when and how long it was unavailable",
+	Usage:       "clair-load-test availability --probe-interval 1s --duration 1h",
+	Action:      availabilityAction,
+	Flags: joinFlags(
+		[]cli.Flag{
+			&cli.DurationFlag{
+				Name:    "probe-interval",
+				Usage:   "--probe-interval 1s",
+				Value:   time.Second,
+				EnvVars: []string{"CLAIR_TEST_PROBE_INTERVAL"},
+			},
+			&cli.DurationFlag{
+				Name:    "probe-timeout",
+				Usage:   "--probe-timeout 1s",
+				Value:   0,
+				EnvVars: []string{"CLAIR_TEST_PROBE_TIMEOUT"},
+			},
+			&cli.StringFlag{
+				Name:    "probe-endpoints",
+				Usage:   "--probe-endpoints index_report,vulnerability_report",
+				Value:   strings.Join(attacker.ProbeEndpoints, ","),
+				EnvVars: []string{"CLAIR_TEST_PROBE_ENDPOINTS"},
+			},
+		},
+		connectionFlags,
+		indexerFlags,
+		clairFlags,
+		[]cli.Flag{durationFlag},
+		transportFlags,
+		preseededFlags,
+		[]cli.Flag{stateDirFlag},
+		authFlags,
+	),
+	Before: func(c *cli.Context) error {
+		if c.Duration("probe-interval") <= 0 {
+			return fmt.Errorf("Invalid probe-interval value. Must be greater than 0")
+		}
+		if c.Duration("probe-timeout") < 0 {
+			return fmt.Errorf("Invalid probe-timeout value. Must not be negative")
+		}
+		if c.IsSet("probe-timeout") && c.IsSet("request-timeout") {
+			return fmt.Errorf("--probe-timeout and --request-timeout both set how long a probe waits. Both are mutually exclusive")
+		}
+		if c.Duration("duration") < 0 {
+			return fmt.Errorf("--duration must not be negative")
+		}
+		endpoints := splitList(c.String("probe-endpoints"))
+		if len(endpoints) == 0 {
+			return fmt.Errorf("Please specify at least one endpoint to probe. Must be among: %v", attacker.ProbeEndpoints)
+		}
+		for _, e := range endpoints {
+			known := false
+			for _, p := range attacker.ProbeEndpoints {
+				known = known || e == p
+			}
+			if !known {
+				return fmt.Errorf("Invalid probe-endpoints value %s. Must be among: %v", e, attacker.ProbeEndpoints)
+			}
+		}
+		if c.String("hashes-file") != "" || c.String("from-runid") != "" {
+			return validatePreseeded(c)
+		}
+		return nil
+	},
+}
+
+// availabilityAction probes clair until the deadline or a signal and reports its availability.
+// It returns an error if any during the execution.
+func availabilityAction(c *cli.Context) error {
+	startTime := time.Now()
+	ctx := c.Context
+	conf, err := NewBaseConfig(c)
+	if err != nil {
+		return err
+	}
+	redact.Add(conf.Auth.PSK, conf.Indexer.Password, conf.Indexer.APIKey)
+	conf.Duration = c.Duration("duration")
+	conf.HashesFile = hashesFile(c)
+	conf.MissingHashes = c.String("missing-hashes")
+	conf.Availability = &attacker.AvailabilityConfig{
+		Interval:  c.Duration("probe-interval"),
+		Endpoints: splitList(c.String("probe-endpoints")),
+		Duration:  conf.Duration,
+	}
+	// A probe hanging past its timeout counts as a failure rather than holding the run up
+	switch {
+	case c.Duration("probe-timeout") > 0:
+		conf.Transport.Timeout = c.Duration("probe-timeout")
+	case !c.IsSet("request-timeout"):
+		conf.Transport.Timeout = conf.Availability.Interval
+	}
+	attackConf, err := NewAttackConfig(c, conf)
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	if conf.HashesFile != "" {
+		if hashes, err = preseededHashes(ctx, 0, conf, attackConf); err != nil {
+			return err
+		}
+	}
+	zlog.Info(ctx).
+		Str("RUNID", conf.RUNID).
+		Strs("endpoints", conf.Availability.Endpoints).
+		Stringer("interval", conf.Availability.Interval).
+		Stringer("timeout", conf.Transport.Timeout).
+		Stringer("duration", conf.Availability.Duration).
+		Int("manifests", len(hashes)).
+		Msg("🩺 Probing clair, signal to stop")
+	err = attacker.RunAvailability(ctx, hashes, *conf.Availability, "availability", attackConf)
+	if err != nil {
+		return fmt.Errorf("Error while measuring availability: %w", err)
+	}
+	zlog.Info(ctx).Stringer("duration", time.Since(startTime)).Msg("Total time taken for completion")
+	return nil
+}
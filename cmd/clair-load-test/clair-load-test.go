@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/quay/clair-load-test/redact"
 	"github.com/quay/zlog"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
@@ -13,9 +17,10 @@ import (
 var logout zerolog.Logger
 
 // createLogger returns a new logger with the specified log level and time format.
+// Secrets are scrubbed out of every line it writes.
 func createLogger(level zerolog.Level, timeFormat string) zerolog.Logger {
 	return zerolog.New(&zerolog.ConsoleWriter{
-		Out:        os.Stderr,
+		Out:        redact.NewWriter(os.Stderr),
 		TimeFormat: timeFormat,
 	}).Level(level).
 		With().
@@ -38,10 +43,24 @@ func setLogLevel(c *cli.Context) error {
 	return nil
 }
 
+// handleSignals cancels the run on the first SIGINT or SIGTERM, so that the partial results
+// get flushed and cleaned up, and exits right away on the second one.
+func handleSignals(cancel context.CancelCauseFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigs
+	logout.Warn().Str("signal", sig.String()).Msg("Stopping, flushing partial results. Signal again to exit immediately")
+	cancel(fmt.Errorf("received %s", sig))
+	sig = <-sigs
+	logout.Error().Str("signal", sig.String()).Msg("Exiting immediately")
+	os.Exit(1)
+}
+
 // main drives the execution for clair-load-test.
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go handleSignals(cancel)
 
 	app := &cli.App{
 		Name:                 "clair-load-test",
@@ -52,7 +71,17 @@ func main() {
 		Before:               setLogLevel,
 		Commands: []*cli.Command{
 			ReportsCmd,
+			CoordinatorCmd,
+			WorkerCmd,
+			CleanupCmd,
+			NotifierCmd,
+			MockClairCmd,
+			ProxyCmd,
+			RecordCmd,
+			ReplayCmd,
+			AvailabilityCmd,
 			CreateTokenCmd,
+			TokenCmd,
 		},
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
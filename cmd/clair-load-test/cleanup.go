@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/auth"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/clair-load-test/state"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// stateDirFlag is the directory state files are written to and looked up in.
+var stateDirFlag = &cli.StringFlag{
+	Name:    "state-dir",
+	Usage:   "--state-dir /var/lib/clair-load-test",
+	Value:   os.TempDir(),
+	EnvVars: []string{"CLAIR_TEST_STATE_DIR"},
+}
+
+// CleanupCmd handles the cleanup CLI.
+var CleanupCmd = &cli.Command{
+	Name:        "cleanup",
+	Description: "Deletes the index reports a previous run left in clair, as listed in its state file",
+	Usage:       "clair-load-test cleanup --runid f519d9b2-aa62-44ab-9ce8-4156b712f6d2",
+	Action:      cleanupAction,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "runid",
+			Usage:   "--runid f519d9b2-aa62-44ab-9ce8-4156b712f6d2",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_RUNID"},
+		},
+		stateDirFlag,
+		&cli.StringFlag{
+			Name:    "state-file",
+			Usage:   "--state-file /tmp/clair-load-test-f519d9b2-aa62-44ab-9ce8-4156b712f6d2.json",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_STATE_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "host",
+			Usage:   "--host localhost:6060/",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_HOST"},
+		},
+		&cli.IntFlag{
+			Name:    "rate",
+			Usage:   "--rate 10",
+			Value:   10,
+			EnvVars: []string{"CLAIR_TEST_CLEANUP_RATE"},
+		},
+		&cli.StringFlag{
+			Name:    "ca-bundle",
+			Usage:   "--ca-bundle /etc/pki/clair/ca.pem",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_CA_BUNDLE"},
+		},
+		&cli.BoolFlag{
+			Name:    "insecure-skip-verify",
+			Usage:   "--insecure-skip-verify",
+			Value:   false,
+			EnvVars: []string{"CLAIR_TEST_INSECURE_SKIP_VERIFY"},
+		},
+	}, authFlags...),
+	Before: func(c *cli.Context) error {
+		if c.String("runid") == "" && c.String("state-file") == "" {
+			return fmt.Errorf("Please specify the run to clean up with --runid or --state-file")
+		}
+		if c.Int("rate") <= 0 {
+			return fmt.Errorf("Invalid rate value. Must be greater than 0")
+		}
+		return nil
+	},
+}
+
+// cleanupAction deletes the index reports listed in a state file.
+// It returns an error if any during the execution.
+func cleanupAction(c *cli.Context) error {
+	ctx := c.Context
+	path := c.String("state-file")
+	if path == "" {
+		path = state.Path(c.String("state-dir"), c.String("runid"))
+	}
+	st, err := state.Load(path)
+	if err != nil {
+		return err
+	}
+	authConf, err := NewAuthConfig(c)
+	if err != nil {
+		return err
+	}
+	redact.Add(authConf.PSK)
+	transport := attacker.DefaultTransport
+	transport.CABundle = c.String("ca-bundle")
+	transport.InsecureSkipVerify = c.Bool("insecure-skip-verify")
+	attackConf := &attacker.AttackConfig{
+		RUNID:       st.RunID,
+		Concurrency: c.Int("rate"),
+		Host:        st.Host,
+		Transport:   transport,
+	}
+	if c.String("host") != "" {
+		attackConf.Host = c.String("host")
+	}
+	attackConf.Auth, err = auth.NewIssuer(authConf)
+	if err != nil {
+		return fmt.Errorf("could not set up authentication: %w", err)
+	}
+	zlog.Info(ctx).Str("RUNID", st.RunID).Str("host", attackConf.Host).Int("manifests", len(st.ManifestHashes)).Msg("🧹 Cleaning up index reports")
+	return deleteLeftovers(ctx, st.ManifestHashes, path, attackConf)
+}
+
+// deleteLeftovers deletes the index reports of a run, then removes its state file or,
+// if some could not be deleted, rewrites it with those only.
+// It returns an error if any index report is left.
+func deleteLeftovers(ctx context.Context, manifestHashes []string, path string, conf *attacker.AttackConfig) error {
+	left, err := attacker.Cleanup(ctx, manifestHashes, conf)
+	if err != nil {
+		return err
+	}
+	if len(left) == 0 {
+		zlog.Info(ctx).Str("RUNID", conf.RUNID).Int("deleted", len(manifestHashes)).Msg("Deleted every index report")
+		return state.Remove(path)
+	}
+	st := &state.State{RunID: conf.RUNID, Host: conf.Host, CreatedAt: time.Now().UTC(), ManifestHashes: left}
+	if err := state.Save(path, st); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d of %d index reports could not be deleted, they are kept in %s", len(left), len(manifestHashes), path)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// CoordinatorCmd runs the report workload with its load spread across workers.
+var CoordinatorCmd = &cli.Command{
+	Name:        "coordinator",
+	Description: "request reports for named containers, generating the load from clair-load-test workers",
+	Usage:       "clair-load-test coordinator --workers http://worker-1:8080,http://worker-2:8080 --worker-secret-file /var/run/secrets/clair-load-test/worker",
+	Action:      reportAction,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "workers",
+			Usage:   "--workers http://worker-1:8080,http://worker-2:8080",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_WORKERS"},
+		},
+		&cli.StringFlag{
+			Name:    "worker-secret",
+			Usage:   "--worker-secret sharedsecret",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_WORKER_SECRET"},
+		},
+		&cli.StringFlag{
+			Name:    "worker-secret-file",
+			Usage:   "--worker-secret-file /var/run/secrets/clair-load-test/worker",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_WORKER_SECRET_FILE"},
+		},
+	}, ReportsCmd.Flags...),
+	Before: func(c *cli.Context) error {
+		if c.String("workers") == "" {
+			return fmt.Errorf("Please specify the workers to coordinate with --workers")
+		}
+		if c.String("worker-secret") == "" && c.String("worker-secret-file") == "" {
+			return fmt.Errorf("Please specify the secret shared with the workers with --worker-secret or --worker-secret-file")
+		}
+		return ReportsCmd.Before(c)
+	},
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/indexer"
+	"github.com/urfave/cli/v2"
+)
+
+// connectionFlags are the options telling which clair the run loads.
+var connectionFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "host",
+		Usage:   "--host localhost:6060/",
+		Value:   "http://localhost:6060/",
+		EnvVars: []string{"CLAIR_TEST_HOST"},
+	},
+	&cli.StringFlag{
+		Name:    "runid",
+		Usage:   "--runid f519d9b2-aa62-44ab-9ce8-4156b712f6d2",
+		Value:   uuid.New().String(),
+		EnvVars: []string{"CLAIR_TEST_RUNID"},
+	},
+}
+
+// manifestFlags are the options selecting the manifests a run indexes.
+var manifestFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "containers",
+		Usage:   "--containers ubuntu:latest,mysql:latest",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_CONTAINERS"},
+	},
+	&cli.StringFlag{
+		Name:    "testrepoprefix",
+		Usage:   "--testrepoprefix quay.io/vchalla/clair-load-test:mysql_8.0.25,quay.io/quay-qetest/clair-load-test:hadoop_latest",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_REPO_PREFIX"},
+	},
+	&cli.IntFlag{
+		Name:    "hitsize",
+		Usage:   "--hitsize 100",
+		Value:   25,
+		EnvVars: []string{"CLAIR_TEST_HIT_SIZE"},
+	},
+	&cli.IntFlag{
+		Name:    "layers",
+		Usage:   "--layers [-1, 5, 10, 15, 20, 25, 30, 35, 40]",
+		Value:   5,
+		EnvVars: []string{"CLAIR_TEST_LAYERS"},
+		Action: func(ctx *cli.Context, v int) error {
+			for _, layer := range validLayers {
+				if layer == v {
+					return nil
+				}
+			}
+			return fmt.Errorf("Invalid layer value. Must be one among: %v", validLayers)
+		},
+	},
+}
+
+// indexerFlags are the options selecting where the phase documents and the samples are indexed.
+var indexerFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "indexer",
+		Usage:   "--indexer [elastic, opensearch, local]",
+		Value:   indexer.OpenSearchIndexer,
+		EnvVars: []string{"CLAIR_TEST_INDEXER"},
+		Action: func(ctx *cli.Context, v string) error {
+			switch v {
+			case indexer.ElasticIndexer, indexer.OpenSearchIndexer, indexer.LocalIndexer:
+				return nil
+			}
+			return fmt.Errorf("Invalid indexer value. Must be one among: %v", []string{indexer.ElasticIndexer, indexer.OpenSearchIndexer, indexer.LocalIndexer})
+		},
+	},
+	&cli.StringFlag{
+		Name:    "es-url",
+		Usage:   "--es-url https://elastic.example.com:9200",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "eshost",
+		Usage:   "--eshost eshosturl (deprecated, use --es-url)",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_HOST"},
+	},
+	&cli.StringFlag{
+		Name:    "esport",
+		Usage:   "--esport esport (deprecated, use --es-url)",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_PORT"},
+	},
+	&cli.StringFlag{
+		Name:    "esindex",
+		Usage:   "--esindex esindex",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_INDEX"},
+	},
+	&cli.StringFlag{
+		Name:    "es-username",
+		Usage:   "--es-username elastic",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_USERNAME"},
+	},
+	&cli.StringFlag{
+		Name:    "es-password",
+		Usage:   "--es-password secret",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_PASSWORD"},
+	},
+	&cli.StringFlag{
+		Name:    "es-password-file",
+		Usage:   "--es-password-file /var/run/secrets/es/password",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_PASSWORD_FILE"},
+	},
+	&cli.StringFlag{
+		Name:    "es-api-key",
+		Usage:   "--es-api-key base64apikey",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_API_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "es-api-key-file",
+		Usage:   "--es-api-key-file /var/run/secrets/es/api-key",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_API_KEY_FILE"},
+	},
+	&cli.StringFlag{
+		Name:    "es-ca-bundle",
+		Usage:   "--es-ca-bundle /etc/pki/ca.pem",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ES_CA_BUNDLE"},
+	},
+	&cli.BoolFlag{
+		Name:    "es-insecure-skip-verify",
+		Usage:   "--es-insecure-skip-verify",
+		Value:   false,
+		EnvVars: []string{"CLAIR_TEST_ES_INSECURE_SKIP_VERIFY"},
+	},
+	&cli.StringFlag{
+		Name:    "samples-index",
+		Usage:   "--samples-index clair-test-samples",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_SAMPLES_INDEX"},
+	},
+	&cli.IntFlag{
+		Name:    "samples-batch-size",
+		Usage:   "--samples-batch-size 1000",
+		Value:   1000,
+		EnvVars: []string{"CLAIR_TEST_SAMPLES_BATCH_SIZE"},
+	},
+	&cli.IntFlag{
+		Name:    "samples-buffer-size",
+		Usage:   "--samples-buffer-size 10000",
+		Value:   10000,
+		EnvVars: []string{"CLAIR_TEST_SAMPLES_BUFFER_SIZE"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-directory",
+		Usage:   "--metrics-directory ./results",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_METRICS_DIRECTORY"},
+	},
+}
+
+// clairFlags are the options describing clair and where its metrics and the faults injected
+// in front of it are read.
+var clairFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "clair-version",
+		Usage:   "--clair-version v4.7.2",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_CLAIR_VERSION"},
+	},
+	&cli.StringFlag{
+		Name:    "clair-metrics-url",
+		Usage:   "--clair-metrics-url http://localhost:8089/metrics",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_METRICS_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "proxy-stats-url",
+		Usage:   "--proxy-stats-url http://localhost:6071/stats",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_PROXY_STATS_URL"},
+	},
+}
+
+// deleteFlags are the options deciding whether the index reports of a run are deleted, and
+// where they are tracked until then.
+var deleteFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:    "delete",
+		Usage:   "--delete",
+		Value:   false,
+		EnvVars: []string{"CLAIR_TEST_INDEX_REPORT_DELETE"},
+	},
+	stateDirFlag,
+}
+
+// concurrencyFlag is the rate of the attacks.
+var concurrencyFlag = &cli.IntFlag{
+	Name:    "concurrency",
+	Usage:   "--concurrency 50",
+	Value:   10,
+	EnvVars: []string{"CLAIR_TEST_CONCURRENCY"},
+}
+
+// requestsFlag stops the attacks after a number of requests.
+var requestsFlag = &cli.IntFlag{
+	Name:    "requests",
+	Usage:   "--requests 1000",
+	Value:   0,
+	EnvVars: []string{"CLAIR_TEST_REQUESTS"},
+}
+
+// durationFlag stops the attacks after a while.
+var durationFlag = &cli.DurationFlag{
+	Name:    "duration",
+	Usage:   "--duration 10m",
+	Value:   0,
+	EnvVars: []string{"CLAIR_TEST_DURATION"},
+}
+
+// transportFlags are the options setting how the attacker talks to clair.
+var transportFlags = []cli.Flag{
+	&cli.DurationFlag{
+		Name:    "request-timeout",
+		Usage:   "--request-timeout 10m",
+		Value:   attacker.DefaultTransport.Timeout,
+		EnvVars: []string{"CLAIR_TEST_REQUEST_TIMEOUT"},
+	},
+	&cli.BoolFlag{
+		Name:    "keepalive",
+		Usage:   "--keepalive=false",
+		Value:   attacker.DefaultTransport.KeepAlive,
+		EnvVars: []string{"CLAIR_TEST_KEEPALIVE"},
+	},
+	&cli.IntFlag{
+		Name:    "max-connections",
+		Usage:   "--max-connections 100",
+		Value:   attacker.DefaultTransport.MaxConnections,
+		EnvVars: []string{"CLAIR_TEST_MAX_CONNECTIONS"},
+	},
+	&cli.IntFlag{
+		Name:    "idle-connections",
+		Usage:   "--idle-connections 100",
+		Value:   attacker.DefaultTransport.IdleConnections,
+		EnvVars: []string{"CLAIR_TEST_IDLE_CONNECTIONS"},
+	},
+	&cli.BoolFlag{
+		Name:    "http2",
+		Usage:   "--http2=false",
+		Value:   attacker.DefaultTransport.HTTP2,
+		EnvVars: []string{"CLAIR_TEST_HTTP2"},
+	},
+	&cli.BoolFlag{
+		Name:    "h2c",
+		Usage:   "--h2c",
+		Value:   attacker.DefaultTransport.H2C,
+		EnvVars: []string{"CLAIR_TEST_H2C"},
+	},
+	&cli.IntFlag{
+		Name:    "redirects",
+		Usage:   "--redirects 10 (-1 to not follow redirects)",
+		Value:   attacker.DefaultTransport.Redirects,
+		EnvVars: []string{"CLAIR_TEST_REDIRECTS"},
+	},
+	&cli.StringFlag{
+		Name:    "proxy",
+		Usage:   "--proxy http://proxy.example.com:3128",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_PROXY"},
+	},
+	&cli.StringFlag{
+		Name:    "ca-bundle",
+		Usage:   "--ca-bundle /etc/pki/clair-ca.pem",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_CA_BUNDLE"},
+	},
+	&cli.StringFlag{
+		Name:    "client-cert",
+		Usage:   "--client-cert /etc/pki/client.crt",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_CLIENT_CERT"},
+	},
+	&cli.StringFlag{
+		Name:    "client-key",
+		Usage:   "--client-key /etc/pki/client.key",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_CLIENT_KEY"},
+	},
+	&cli.BoolFlag{
+		Name:    "insecure-skip-verify",
+		Usage:   "--insecure-skip-verify",
+		Value:   false,
+		EnvVars: []string{"CLAIR_TEST_INSECURE_SKIP_VERIFY"},
+	},
+	&cli.StringFlag{
+		Name:    "local-addr",
+		Usage:   "--local-addr 10.0.0.5",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_LOCAL_ADDR"},
+	},
+}
+
+// pacingFlags are the options setting the order and the arrival times of the requests.
+var pacingFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "targeter",
+		Usage:   "--targeter [cycle, exhaust]",
+		Value:   attacker.TargeterCycle,
+		EnvVars: []string{"CLAIR_TEST_TARGETER"},
+		Action: func(ctx *cli.Context, v string) error {
+			if v != attacker.TargeterCycle && v != attacker.TargeterExhaust {
+				return fmt.Errorf("Invalid targeter value. Must be one among: %v", []string{attacker.TargeterCycle, attacker.TargeterExhaust})
+			}
+			return nil
+		},
+	},
+	&cli.StringFlag{
+		Name:    "arrivals",
+		Usage:   "--arrivals [constant, poisson, trace]",
+		Value:   attacker.ArrivalsConstant,
+		EnvVars: []string{"CLAIR_TEST_ARRIVALS"},
+		Action: func(ctx *cli.Context, v string) error {
+			switch v {
+			case attacker.ArrivalsConstant, attacker.ArrivalsPoisson, attacker.ArrivalsTrace:
+				return nil
+			}
+			return fmt.Errorf("Invalid arrivals value. Must be one among: %v", []string{attacker.ArrivalsConstant, attacker.ArrivalsPoisson, attacker.ArrivalsTrace})
+		},
+	},
+	&cli.StringFlag{
+		Name:    "arrival-trace",
+		Usage:   "--arrival-trace /tmp/arrivals.txt",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_ARRIVAL_TRACE"},
+	},
+	&cli.Float64Flag{
+		Name:    "trace-speed",
+		Usage:   "--trace-speed 2",
+		Value:   1,
+		EnvVars: []string{"CLAIR_TEST_TRACE_SPEED"},
+		Action: func(ctx *cli.Context, v float64) error {
+			if v <= 0 {
+				return fmt.Errorf("Invalid trace-speed value. Must be greater than 0")
+			}
+			return nil
+		},
+	},
+}
+
+// preseededFlags are the options pointing at manifests indexed by an earlier run.
+var preseededFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "hashes-file",
+		Usage:   "--hashes-file /tmp/manifest-hashes.txt",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_HASHES_FILE"},
+	},
+	&cli.StringFlag{
+		Name:    "from-runid",
+		Usage:   "--from-runid f519d9b2-aa62-44ab-9ce8-4156b712f6d2",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_FROM_RUNID"},
+	},
+	&cli.StringFlag{
+		Name:    "missing-hashes",
+		Usage:   "--missing-hashes [skip, warn]",
+		Value:   missingHashesSkip,
+		EnvVars: []string{"CLAIR_TEST_MISSING_HASHES"},
+		Action: func(ctx *cli.Context, v string) error {
+			if v != missingHashesSkip && v != missingHashesWarn {
+				return fmt.Errorf("Invalid missing-hashes value. Must be one among: %v", []string{missingHashesSkip, missingHashesWarn})
+			}
+			return nil
+		},
+	},
+}
+
+// phaseFlags are the options selecting the phases of the report workload and tuning them.
+var phaseFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "phases",
+		Usage:   "--phases post_index_report,get_vulnerability_report",
+		Value:   defaultPhases,
+		EnvVars: []string{"CLAIR_TEST_PHASES"},
+		Action: func(ctx *cli.Context, v string) error {
+			if len(splitList(v)) == 0 {
+				return fmt.Errorf("Please specify at least one phase. Must be among: %v", validPhases)
+			}
+			return validatePhases(splitList(v))
+		},
+	},
+	&cli.StringFlag{
+		Name:    "vulnerabilities",
+		Usage:   "--vulnerabilities [synthetic, harvested]",
+		Value:   attacker.VulnerabilitiesSynthetic,
+		EnvVars: []string{"CLAIR_TEST_VULNERABILITIES"},
+		Action: func(ctx *cli.Context, v string) error {
+			if v != attacker.VulnerabilitiesSynthetic && v != attacker.VulnerabilitiesHarvested {
+				return fmt.Errorf("Invalid vulnerabilities value. Must be one among: %v", []string{attacker.VulnerabilitiesSynthetic, attacker.VulnerabilitiesHarvested})
+			}
+			return nil
+		},
+	},
+	&cli.IntFlag{
+		Name:    "vulnerability-batch-size",
+		Usage:   "--vulnerability-batch-size 100",
+		Value:   100,
+		EnvVars: []string{"CLAIR_TEST_VULNERABILITY_BATCH_SIZE"},
+		Action: func(ctx *cli.Context, v int) error {
+			if v <= 0 {
+				return fmt.Errorf("Invalid vulnerability-batch-size value. Must be greater than 0")
+			}
+			return nil
+		},
+	},
+	&cli.DurationFlag{
+		Name:    "session-poll-interval",
+		Usage:   "--session-poll-interval 1s",
+		Value:   time.Second,
+		EnvVars: []string{"CLAIR_TEST_SESSION_POLL_INTERVAL"},
+	},
+	&cli.DurationFlag{
+		Name:    "session-poll-timeout",
+		Usage:   "--session-poll-timeout 10m",
+		Value:   10 * time.Minute,
+		EnvVars: []string{"CLAIR_TEST_SESSION_POLL_TIMEOUT"},
+	},
+	&cli.IntFlag{
+		Name:    "session-retries",
+		Usage:   "--session-retries 3",
+		Value:   3,
+		EnvVars: []string{"CLAIR_TEST_SESSION_RETRIES"},
+	},
+	&cli.DurationFlag{
+		Name:    "session-retry-backoff",
+		Usage:   "--session-retry-backoff 1s",
+		Value:   time.Second,
+		EnvVars: []string{"CLAIR_TEST_SESSION_RETRY_BACKOFF"},
+	},
+	&cli.StringFlag{
+		Name:    "session-buckets",
+		Usage:   "--session-buckets 100ms,1s,10s,1m",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_SESSION_BUCKETS"},
+		Action: func(ctx *cli.Context, v string) error {
+			_, err := parseBuckets(v)
+			return err
+		},
+	},
+	&cli.StringFlag{
+		Name:    "phase-rates",
+		Usage:   "--phase-rates get_vulnerability_report=50,post_index_report=5",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_PHASE_RATES"},
+		Action: func(ctx *cli.Context, v string) error {
+			rates, err := parsePhaseValues(v)
+			if err != nil {
+				return err
+			}
+			for phase, rate := range rates {
+				if rate <= 0 {
+					return fmt.Errorf("Invalid rate for phase %s. Must be greater than 0", phase)
+				}
+			}
+			return nil
+		},
+	},
+	&cli.StringFlag{
+		Name:    "phase-requests",
+		Usage:   "--phase-requests get_vulnerability_report=1000",
+		Value:   "",
+		EnvVars: []string{"CLAIR_TEST_PHASE_REQUESTS"},
+		Action: func(ctx *cli.Context, v string) error {
+			_, err := parsePhaseValues(v)
+			return err
+		},
+	},
+}
+
+// soakFlags are the options of soak runs.
+var soakFlags = []cli.Flag{
+	&cli.DurationFlag{
+		Name:    "soak-duration",
+		Usage:   "--soak-duration 12h",
+		Value:   0,
+		EnvVars: []string{"CLAIR_TEST_SOAK_DURATION"},
+		Action: func(ctx *cli.Context, v time.Duration) error {
+			if v < 0 {
+				return fmt.Errorf("Invalid soak-duration value. Must not be negative")
+			}
+			return nil
+		},
+	},
+	&cli.DurationFlag{
+		Name:    "soak-window",
+		Usage:   "--soak-window 10m",
+		Value:   10 * time.Minute,
+		EnvVars: []string{"CLAIR_TEST_SOAK_WINDOW"},
+		Action: func(ctx *cli.Context, v time.Duration) error {
+			if v <= 0 {
+				return fmt.Errorf("Invalid soak-window value. Must be greater than 0")
+			}
+			return nil
+		},
+	},
+	&cli.Float64Flag{
+		Name:    "soak-latency-trend",
+		Usage:   "--soak-latency-trend 0.2",
+		Value:   0.2,
+		EnvVars: []string{"CLAIR_TEST_SOAK_LATENCY_TREND"},
+		Action: func(ctx *cli.Context, v float64) error {
+			if v < 0 {
+				return fmt.Errorf("Invalid soak-latency-trend value. Must not be negative")
+			}
+			return nil
+		},
+	},
+	&cli.Float64Flag{
+		Name:    "soak-error-trend",
+		Usage:   "--soak-error-trend 0.01",
+		Value:   0.01,
+		EnvVars: []string{"CLAIR_TEST_SOAK_ERROR_TREND"},
+		Action: func(ctx *cli.Context, v float64) error {
+			if v < 0 || v > 1 {
+				return fmt.Errorf("Invalid soak-error-trend value. Must be between 0 and 1")
+			}
+			return nil
+		},
+	},
+}
+
+// joinFlags puts groups of options together, in order.
+// It returns the list of flags.
+func joinFlags(groups ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	for _, g := range groups {
+		flags = append(flags, g...)
+	}
+	return flags
+}
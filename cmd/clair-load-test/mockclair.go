@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/mockclair"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// MockClairCmd handles the mock-clair CLI.
+var MockClairCmd = &cli.Command{
+	Name:        "mock-clair",
+	Description: "Serves the indexer, matcher and notifier APIs of clair from memory, to work on the tool and on scenarios offline",
+	Usage:       "clair-load-test mock-clair --listen :6060 --latency normal:200ms:50ms",
+	Action:      mockClairAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "listen",
+			Usage:   "--listen :6060",
+			Value:   ":6060",
+			EnvVars: []string{"CLAIR_TEST_MOCK_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "url",
+			Usage:   "--url http://mock-clair:6060",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_MOCK_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "psk",
+			Usage:   "--psk secretkey",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_PSK"},
+		},
+		pskFileFlag,
+		&cli.StringFlag{
+			Name:    "latency",
+			Usage:   "--latency [200ms, uniform:200ms:50ms, normal:200ms:50ms, exponential:200ms]",
+			Value:   "0s",
+			EnvVars: []string{"CLAIR_TEST_MOCK_LATENCY"},
+			Action: func(ctx *cli.Context, v string) error {
+				_, err := mockclair.ParseLatency(v)
+				return err
+			},
+		},
+		&cli.Float64Flag{
+			Name:    "error-rate",
+			Usage:   "--error-rate 0.01",
+			Value:   0,
+			EnvVars: []string{"CLAIR_TEST_MOCK_ERROR_RATE"},
+		},
+		&cli.IntFlag{
+			Name:    "error-code",
+			Usage:   "--error-code 503",
+			Value:   http.StatusServiceUnavailable,
+			EnvVars: []string{"CLAIR_TEST_MOCK_ERROR_CODE"},
+		},
+		&cli.StringFlag{
+			Name:    "route-latencies",
+			Usage:   "--route-latencies index_report=normal:2s:500ms,vulnerability_report=exponential:100ms",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_MOCK_ROUTE_LATENCIES"},
+		},
+		&cli.StringFlag{
+			Name:    "route-errors",
+			Usage:   "--route-errors index_report=0.05:500,notification=0.01",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_MOCK_ROUTE_ERRORS"},
+		},
+		&cli.DurationFlag{
+			Name:    "index-delay",
+			Usage:   "--index-delay 5s",
+			Value:   0,
+			EnvVars: []string{"CLAIR_TEST_MOCK_INDEX_DELAY"},
+		},
+		&cli.StringFlag{
+			Name:    "webhook",
+			Usage:   "--webhook http://localhost:8090/",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_MOCK_WEBHOOK"},
+		},
+		&cli.DurationFlag{
+			Name:    "notification-interval",
+			Usage:   "--notification-interval 10s",
+			Value:   10 * time.Second,
+			EnvVars: []string{"CLAIR_TEST_MOCK_NOTIFICATION_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    "notification-size",
+			Usage:   "--notification-size 100",
+			Value:   100,
+			EnvVars: []string{"CLAIR_TEST_MOCK_NOTIFICATION_SIZE"},
+		},
+	},
+}
+
+// NewMockClairConfig creates the mock server configuration from CLI options.
+// It returns the configuration and an error if any of the options is malformed.
+func NewMockClairConfig(c *cli.Context) (mockclair.Config, error) {
+	psk, err := secretOption(c, "psk", "psk-file")
+	if err != nil {
+		return mockclair.Config{}, err
+	}
+	latency, err := mockclair.ParseLatency(c.String("latency"))
+	if err != nil {
+		return mockclair.Config{}, err
+	}
+	conf := mockclair.Config{
+		PSK: psk,
+		Default: mockclair.Behavior{
+			Latency:   latency,
+			ErrorRate: c.Float64("error-rate"),
+			ErrorCode: c.Int("error-code"),
+		},
+		Routes:               map[string]mockclair.Behavior{},
+		IndexDelay:           c.Duration("index-delay"),
+		URL:                  c.String("url"),
+		Webhook:              c.String("webhook"),
+		NotificationInterval: c.Duration("notification-interval"),
+		NotificationSize:     c.Int("notification-size"),
+	}
+	if conf.URL == "" {
+		_, port, _ := net.SplitHostPort(c.String("listen"))
+		conf.URL = "http://localhost:" + port
+	}
+	// Routes start from the default behaviour and override part of it
+	route := func(name string) mockclair.Behavior {
+		if b, ok := conf.Routes[name]; ok {
+			return b
+		}
+		return conf.Default
+	}
+	for _, entry := range splitList(c.String("route-latencies")) {
+		name, v, ok := strings.Cut(entry, "=")
+		if !ok {
+			return mockclair.Config{}, fmt.Errorf("Invalid route latency %q. Must look like: index_report=normal:2s:500ms", entry)
+		}
+		b := route(strings.TrimSpace(name))
+		if b.Latency, err = mockclair.ParseLatency(v); err != nil {
+			return mockclair.Config{}, err
+		}
+		conf.Routes[strings.TrimSpace(name)] = b
+	}
+	for _, entry := range splitList(c.String("route-errors")) {
+		name, v, ok := strings.Cut(entry, "=")
+		if !ok {
+			return mockclair.Config{}, fmt.Errorf("Invalid route errors %q. Must look like: index_report=0.05:500", entry)
+		}
+		b := route(strings.TrimSpace(name))
+		rate, code, hasCode := strings.Cut(v, ":")
+		if b.ErrorRate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return mockclair.Config{}, fmt.Errorf("Invalid route errors %q. Must look like: index_report=0.05:500", entry)
+		}
+		if hasCode {
+			if b.ErrorCode, err = strconv.Atoi(code); err != nil || b.ErrorCode < 100 || b.ErrorCode > 599 {
+				return mockclair.Config{}, fmt.Errorf("Invalid route errors %q. Must look like: index_report=0.05:500", entry)
+			}
+		}
+		conf.Routes[strings.TrimSpace(name)] = b
+	}
+	return conf, nil
+}
+
+// mockClairAction serves the mock clair APIs until the run is interrupted.
+// It returns an error if any during the execution.
+func mockClairAction(c *cli.Context) error {
+	ctx := c.Context
+	conf, err := NewMockClairConfig(c)
+	if err != nil {
+		return err
+	}
+	redact.Add(conf.PSK)
+	srv, err := mockclair.New(conf)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", c.String("listen"))
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+	hs := &http.Server{Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Notify(ctx)
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(attacker.Detach(ctx), 5*time.Second)
+		defer cancel()
+		hs.Shutdown(sctx)
+	}()
+	zlog.Info(ctx).
+		Str("listen", ln.Addr().String()).
+		Str("url", conf.URL).
+		Bool("auth", conf.PSK != "").
+		Str("webhook", conf.Webhook).
+		Msg("🧪 Serving mock clair")
+	if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
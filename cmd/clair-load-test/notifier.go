@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// NotifierCmd handles the notifier CLI.
+var NotifierCmd = &cli.Command{
+	Name:        "notifier",
+	Description: "Receives the callbacks of clair's notifier on a webhook, then pages through and deletes the notifications",
+	Usage:       "clair-load-test notifier --listen :8090 --notifications 100",
+	Action:      notifierAction,
+	Flags: joinFlags(
+		[]cli.Flag{
+			&cli.StringFlag{
+				Name:    "listen",
+				Usage:   "--listen :8090",
+				Value:   ":8090",
+				EnvVars: []string{"CLAIR_TEST_WEBHOOK_LISTEN"},
+			},
+			&cli.IntFlag{
+				Name:    "page-size",
+				Usage:   "--page-size 100",
+				Value:   100,
+				EnvVars: []string{"CLAIR_TEST_NOTIFICATION_PAGE_SIZE"},
+			},
+			&cli.IntFlag{
+				Name:    "notifications",
+				Usage:   "--notifications 100",
+				Value:   0,
+				EnvVars: []string{"CLAIR_TEST_NOTIFICATIONS"},
+			},
+			&cli.BoolFlag{
+				Name:    "delete-notifications",
+				Usage:   "--delete-notifications=false",
+				Value:   true,
+				EnvVars: []string{"CLAIR_TEST_NOTIFICATION_DELETE"},
+			},
+		},
+		connectionFlags,
+		indexerFlags,
+		clairFlags,
+		[]cli.Flag{concurrencyFlag, durationFlag},
+		transportFlags,
+		authFlags,
+	),
+	Before: func(c *cli.Context) error {
+		if c.Int("concurrency") <= 0 || c.Duration("duration") < 0 || c.Int("notifications") < 0 {
+			return fmt.Errorf("--concurrency must be positive, --duration and --notifications must not be negative")
+		}
+		if c.Int("page-size") <= 0 {
+			return fmt.Errorf("Invalid page-size value. Must be greater than 0")
+		}
+		if c.Duration("duration") == 0 && c.Int("notifications") == 0 {
+			return fmt.Errorf("Please specify when to stop with --duration or --notifications")
+		}
+		return nil
+	},
+}
+
+// notifierAction serves the webhook and runs the notifier workload against clair.
+// It returns an error if any during the execution.
+func notifierAction(c *cli.Context) error {
+	startTime := time.Now()
+	ctx := c.Context
+	conf, err := NewBaseConfig(c)
+	if err != nil {
+		return err
+	}
+	redact.Add(conf.Auth.PSK, conf.Indexer.Password, conf.Indexer.APIKey)
+	conf.Concurrency = c.Int("concurrency")
+	conf.Duration = c.Duration("duration")
+	conf.Notifier = &attacker.NotifierConfig{
+		PageSize:      c.Int("page-size"),
+		Notifications: c.Int("notifications"),
+		Duration:      conf.Duration,
+		Delete:        c.Bool("delete-notifications"),
+	}
+	attackConf, err := NewAttackConfig(c, conf)
+	if err != nil {
+		return err
+	}
+
+	hook := attacker.NewWebhook()
+	ln, err := net.Listen("tcp", c.String("listen"))
+	if err != nil {
+		return fmt.Errorf("could not listen for callbacks: %w", err)
+	}
+	srv := &http.Server{Handler: hook, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zlog.Error(ctx).Err(err).Msg("webhook server failure")
+		}
+	}()
+	defer func() {
+		sctx, cancel := context.WithTimeout(attacker.Detach(ctx), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+
+	zlog.Info(ctx).
+		Str("RUNID", conf.RUNID).
+		Str("webhook", ln.Addr().String()).
+		Int("notifications", conf.Notifier.Notifications).
+		Stringer("duration", conf.Notifier.Duration).
+		Msg("🔔 Waiting for notifier callbacks")
+	err = attacker.RunNotifier(ctx, hook, *conf.Notifier, "notifier", attackConf)
+	if err != nil {
+		return fmt.Errorf("Error while running the notifier workload: %w", err)
+	}
+	zlog.Info(ctx).Stringer("duration", time.Since(startTime)).Msg("Total time taken for completion")
+	return nil
+}
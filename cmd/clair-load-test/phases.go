@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+)
+
+// Phases of the workload
+const (
+	phasePostIndexReport        = "post_index_report"
+	phaseGetIndexReport         = "get_index_report"
+	phaseGetVulnerabilityReport = "get_vulnerability_report"
+	phaseGetIndexerState        = "get_indexer_state"
+	phaseDeleteIndexReport      = "delete_index_report"
+	phaseGetUpdateOperation     = "get_update_operation"
+	phaseGetUpdateDiff          = "get_update_diff"
+	phasePostAffectedManifest   = "post_affected_manifest"
+	phaseSession                = "session"
+	defaultPhases               = phasePostIndexReport + "," + phaseGetIndexReport + "," + phaseGetVulnerabilityReport + "," + phaseGetIndexerState
+)
+
+// phaseOperations describes the operation of every phase, in error messages.
+var phaseOperations = map[string]string{
+	phasePostIndexReport:        "POST operation on index_report",
+	phaseGetIndexReport:         "GET operation on index_report",
+	phaseGetVulnerabilityReport: "GET operation on vulnerability_report",
+	phaseGetIndexerState:        "GET operation on indexer_state",
+	phaseDeleteIndexReport:      "DELETE operation on index_report",
+	phaseGetUpdateOperation:     "GET operation on update_operation",
+	phaseGetUpdateDiff:          "GET operation on update_diff",
+	phasePostAffectedManifest:   "POST operation on affected_manifest",
+	phaseSession:                "sessions of POST index_report, polling and GET vulnerability_report",
+}
+
+// validPhases lists the phases that can be selected, in their default order.
+var validPhases = []string{phasePostIndexReport, phaseGetIndexReport, phaseGetVulnerabilityReport, phaseGetIndexerState, phaseDeleteIndexReport, phaseGetUpdateOperation, phaseGetUpdateDiff, phasePostAffectedManifest, phaseSession}
+
+// validatePhases makes sure every selected phase exists.
+// It returns an error naming the first unknown phase.
+func validatePhases(phases []string) error {
+	for _, p := range phases {
+		if _, ok := phaseOperations[p]; !ok {
+			return fmt.Errorf("Invalid phase %q. Must be one among: %v", p, validPhases)
+		}
+	}
+	return nil
+}
+
+// parsePhaseValues parses per phase overrides such as "get_vulnerability_report=50,post_index_report=5".
+// It returns the values by phase and an error if an entry is malformed or names an unknown phase.
+func parsePhaseValues(s string) (map[string]int, error) {
+	values := map[string]int{}
+	for _, entry := range splitList(s) {
+		phase, v, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid phase value %q. Must look like: get_vulnerability_report=50,post_index_report=5", entry)
+		}
+		phase = strings.TrimSpace(phase)
+		if err := validatePhases([]string{phase}); err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid phase value %q. Must be a non negative integer", entry)
+		}
+		values[phase] = n
+	}
+	return values, nil
+}
+
+// selectPhases works out the phases to run, in order. With --delete, the
+// index reports are deleted last unless the deletion was placed explicitly.
+// It returns the list of phases.
+func selectPhases(phases []string, indexDelete bool) []string {
+	if !indexDelete {
+		return phases
+	}
+	for _, p := range phases {
+		if p == phaseDeleteIndexReport {
+			return phases
+		}
+	}
+	return append(phases, phaseDeleteIndexReport)
+}
+
+// phaseRequests builds the requests of a phase. Update diffs are requested between the
+// update operations clair lists when the phase starts, and affected manifests are looked up
+// for one batch of vulnerabilities per manifest.
+// It returns the list of requests and an error if they could not be built.
+func phaseRequests(ctx context.Context, phase string, manifests [][]byte, manifestHashes []string, jwt_token string, conf *TestConfig, attackConf *attacker.AttackConfig) ([]map[string]interface{}, error) {
+	switch phase {
+	case phasePostIndexReport:
+		return attacker.CreateIndexReportRequests(ctx, manifests, conf.Host, jwt_token), nil
+	case phaseGetIndexReport:
+		return attacker.GetIndexReportRequests(ctx, manifestHashes, conf.Host, jwt_token), nil
+	case phaseGetVulnerabilityReport:
+		return attacker.GetVulnerabilityReportRequests(ctx, manifestHashes, conf.Host, jwt_token), nil
+	case phaseGetIndexerState:
+		return attacker.GetIndexerStateRequests(ctx, len(manifestHashes), conf.Host, jwt_token), nil
+	case phaseDeleteIndexReport:
+		return attacker.DeleteIndexReportsRequests(ctx, manifestHashes, conf.Host, jwt_token), nil
+	case phaseGetUpdateOperation:
+		return attacker.GetUpdateOperationRequests(ctx, len(manifestHashes), conf.Host, jwt_token), nil
+	case phaseGetUpdateDiff:
+		diffs, err := attacker.DiscoverUpdateDiffs(ctx, attackConf)
+		if err != nil {
+			return nil, err
+		}
+		return attacker.GetUpdateDiffRequests(ctx, diffs, conf.Host, jwt_token), nil
+	case phasePostAffectedManifest:
+		batches := len(manifestHashes)
+		if batches == 0 {
+			batches = 1
+		}
+		var vulns []json.RawMessage
+		switch conf.Vulnerabilities {
+		case attacker.VulnerabilitiesHarvested:
+			var err error
+			vulns, err = attacker.HarvestVulnerabilities(ctx, manifestHashes, attackConf)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			vulns = attacker.SyntheticVulnerabilities(batches * conf.VulnerabilityBatch)
+		}
+		return attacker.AffectedManifestRequests(ctx, vulns, batches, conf.VulnerabilityBatch, conf.Host, jwt_token), nil
+	}
+	return nil, nil
+}
+
+// phaseConf applies the rate and request count overrides of a phase.
+// It returns the attack configuration of the phase.
+func phaseConf(attackConf *attacker.AttackConfig, conf *TestConfig, phase string) *attacker.AttackConfig {
+	pc := *attackConf
+	if rate, ok := conf.PhaseRates[phase]; ok && rate > 0 {
+		pc.Concurrency = rate
+	}
+	if requests, ok := conf.PhaseRequests[phase]; ok {
+		pc.Requests = uint64(requests)
+	}
+	return &pc
+}
+
+// parseBuckets parses the upper bounds of histogram buckets such as "100ms,1s,10s".
+// It returns the bounds, nil when none is given, and an error if they are malformed or not increasing.
+func parseBuckets(s string) ([]time.Duration, error) {
+	var bounds []time.Duration
+	for _, v := range splitList(s) {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || (len(bounds) > 0 && d <= bounds[len(bounds)-1]) {
+			return nil, fmt.Errorf("Invalid bucket %q. Buckets must be increasing positive durations such as: 100ms,1s,10s", v)
+		}
+		bounds = append(bounds, d)
+	}
+	return bounds, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/faultproxy"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// ProxyCmd handles the fault injecting proxy CLI.
+var ProxyCmd = &cli.Command{
+	Name:        "proxy",
+	Description: "Forwards requests to clair, or clair's to its registry, injecting latency, resets, errors and throttling",
+	Usage:       "clair-load-test proxy --upstream http://clair:6060 --rules '/indexer/*=latency:2s,reset:0.01'",
+	Before: func(c *cli.Context) error {
+		if (c.String("upstream") == "") == (c.String("forward-proxy") == "") {
+			return fmt.Errorf("Please specify either where to forward requests to with --upstream or the hosts to act as an HTTP proxy for with --forward-proxy")
+		}
+		return nil
+	},
+	Action: proxyAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "listen",
+			Usage:   "--listen 127.0.0.1:6070",
+			Value:   "127.0.0.1:6070",
+			EnvVars: []string{"CLAIR_TEST_FAULT_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "stats-listen",
+			Usage:   "--stats-listen 127.0.0.1:6071",
+			Value:   "127.0.0.1:6071",
+			EnvVars: []string{"CLAIR_TEST_FAULT_STATS_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "upstream",
+			Usage:   "--upstream http://clair:6060",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_FAULT_UPSTREAM"},
+		},
+		&cli.StringFlag{
+			Name:    "forward-proxy",
+			Usage:   "--forward-proxy 'quay.io,*.quay.io,registry.local:5000'",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_FAULT_FORWARD_PROXY"},
+		},
+		&cli.DurationFlag{
+			Name:    "latency",
+			Usage:   "--latency 200ms",
+			Value:   0,
+			EnvVars: []string{"CLAIR_TEST_FAULT_LATENCY"},
+		},
+		&cli.DurationFlag{
+			Name:    "jitter",
+			Usage:   "--jitter 50ms",
+			Value:   0,
+			EnvVars: []string{"CLAIR_TEST_FAULT_JITTER"},
+		},
+		&cli.Float64Flag{
+			Name:    "reset-rate",
+			Usage:   "--reset-rate 0.01",
+			Value:   0,
+			EnvVars: []string{"CLAIR_TEST_FAULT_RESET_RATE"},
+		},
+		&cli.Float64Flag{
+			Name:    "error-rate",
+			Usage:   "--error-rate 0.01",
+			Value:   0,
+			EnvVars: []string{"CLAIR_TEST_FAULT_ERROR_RATE"},
+		},
+		&cli.IntFlag{
+			Name:    "error-code",
+			Usage:   "--error-code 503",
+			Value:   http.StatusServiceUnavailable,
+			EnvVars: []string{"CLAIR_TEST_FAULT_ERROR_CODE"},
+		},
+		&cli.Int64Flag{
+			Name:    "bandwidth",
+			Usage:   "--bandwidth 1048576",
+			Value:   0,
+			EnvVars: []string{"CLAIR_TEST_FAULT_BANDWIDTH"},
+		},
+		&cli.StringFlag{
+			Name:    "rules",
+			Usage:   "--rules '/indexer/api/v1/index_report*=latency:2s,jitter:500ms,reset:0.01;/matcher/*=error:0.05:500,bandwidth:65536'",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_FAULT_RULES"},
+		},
+	},
+}
+
+// NewProxyConfig creates the proxy configuration from CLI options.
+// It returns the configuration and an error if any of the faults is malformed.
+func NewProxyConfig(c *cli.Context) (faultproxy.Config, error) {
+	conf := faultproxy.Config{
+		Upstream: c.String("upstream"),
+		Forward:  splitList(c.String("forward-proxy")),
+		Default: faultproxy.Faults{
+			Latency:   c.Duration("latency"),
+			Jitter:    c.Duration("jitter"),
+			ResetRate: c.Float64("reset-rate"),
+			ErrorRate: c.Float64("error-rate"),
+			ErrorCode: c.Int("error-code"),
+			Bandwidth: c.Int64("bandwidth"),
+		},
+	}
+	var err error
+	conf.Rules, err = faultproxy.ParseRules(conf.Default, c.String("rules"))
+	if err != nil {
+		return faultproxy.Config{}, err
+	}
+	return conf, nil
+}
+
+// proxyAction forwards requests and serves the stats of the injected faults until the run is interrupted.
+// It returns an error if any during the execution.
+func proxyAction(c *cli.Context) error {
+	ctx := c.Context
+	conf, err := NewProxyConfig(c)
+	if err != nil {
+		return err
+	}
+	p, err := faultproxy.New(conf)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", c.String("listen"))
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+	statsLn, err := net.Listen("tcp", c.String("stats-listen"))
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("could not listen for stats: %w", err)
+	}
+	hs := &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	mux := http.NewServeMux()
+	mux.Handle("/stats", p.StatsHandler())
+	stats := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(attacker.Detach(ctx), 5*time.Second)
+		defer cancel()
+		stats.Shutdown(sctx)
+		hs.Shutdown(sctx)
+	}()
+	go func() {
+		if err := stats.Serve(statsLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zlog.Warn(ctx).Err(err).Msg("stopped serving proxy stats")
+		}
+	}()
+	zlog.Info(ctx).
+		Str("listen", ln.Addr().String()).
+		Str("stats", "http://"+statsLn.Addr().String()+"/stats").
+		Str("upstream", conf.Upstream).
+		Strs("forward", conf.Forward).
+		Int("rules", len(conf.Rules)).
+		Msg("🔌 Injecting faults")
+	if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
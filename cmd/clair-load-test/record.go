@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/recording"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// RecordCmd handles the record CLI.
+var RecordCmd = &cli.Command{
+	Name:        "record",
+	Description: "Forwards requests to clair, writing every one of them down to be replayed later",
+	Usage:       "clair-load-test record --upstream http://clair:6060 --recording traffic.jsonl",
+	Action:      recordAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "listen",
+			Usage:   "--listen :6080",
+			Value:   ":6080",
+			EnvVars: []string{"CLAIR_TEST_RECORD_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:     "upstream",
+			Usage:    "--upstream http://clair:6060",
+			Value:    "",
+			Required: true,
+			EnvVars:  []string{"CLAIR_TEST_RECORD_UPSTREAM"},
+		},
+		&cli.StringFlag{
+			Name:     "recording",
+			Usage:    "--recording traffic.jsonl",
+			Value:    "",
+			Required: true,
+			EnvVars:  []string{"CLAIR_TEST_RECORDING"},
+		},
+	},
+}
+
+// recordAction forwards and records requests until the run is interrupted.
+// It returns an error if any during the execution.
+func recordAction(c *cli.Context) error {
+	ctx := c.Context
+	f, err := os.Create(c.String("recording"))
+	if err != nil {
+		return fmt.Errorf("could not create recording: %w", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	rec, err := recording.NewRecorder(c.String("upstream"), w)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", c.String("listen"))
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+	hs := &http.Server{Handler: rec, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(attacker.Detach(ctx), 30*time.Second)
+		defer cancel()
+		hs.Shutdown(sctx)
+	}()
+	zlog.Info(ctx).
+		Str("listen", ln.Addr().String()).
+		Str("upstream", c.String("upstream")).
+		Str("recording", c.String("recording")).
+		Msg("📼 Recording requests")
+	if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// Serve returns as soon as the shutdown starts, wait for the requests in flight to be written down
+	sctx, cancel := context.WithTimeout(attacker.Detach(ctx), 30*time.Second)
+	defer cancel()
+	hs.Shutdown(sctx)
+	count, err := rec.Recorded()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("could not write recording: %w", err)
+	}
+	zlog.Info(ctx).Uint64("requests", count).Str("recording", c.String("recording")).Msg("Recording saved")
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/recording"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// ReplayCmd handles the replay CLI.
+var ReplayCmd = &cli.Command{
+	Name:        "replay",
+	Description: "Plays requests recorded by the record command back against clair, with their recorded or scaled timing",
+	Usage:       "clair-load-test replay --recording traffic.jsonl --speed 2",
+	Action:      replayAction,
+	Flags: joinFlags(
+		[]cli.Flag{
+			&cli.StringFlag{
+				Name:     "recording",
+				Usage:    "--recording traffic.jsonl",
+				Value:    "",
+				Required: true,
+				EnvVars:  []string{"CLAIR_TEST_RECORDING"},
+			},
+			&cli.Float64Flag{
+				Name:    "speed",
+				Usage:   "--speed 2",
+				Value:   1,
+				EnvVars: []string{"CLAIR_TEST_REPLAY_SPEED"},
+			},
+		},
+		connectionFlags,
+		indexerFlags,
+		clairFlags,
+		[]cli.Flag{requestsFlag, durationFlag},
+		transportFlags,
+		authFlags,
+	),
+	Before: func(c *cli.Context) error {
+		if c.Float64("speed") <= 0 {
+			return fmt.Errorf("Invalid speed value. Must be greater than 0")
+		}
+		if c.Int("requests") < 0 || c.Duration("duration") < 0 {
+			return fmt.Errorf("--requests and --duration must not be negative")
+		}
+		return nil
+	},
+}
+
+// replayAction plays a recording back against clair and reports it as a single phase.
+// It returns an error if any during the execution.
+func replayAction(c *cli.Context) error {
+	startTime := time.Now()
+	ctx := c.Context
+	conf, err := NewBaseConfig(c)
+	if err != nil {
+		return err
+	}
+	redact.Add(conf.Auth.PSK, conf.Indexer.Password, conf.Indexer.APIKey)
+	conf.Requests = c.Int("requests")
+	conf.Duration = c.Duration("duration")
+	conf.Replay = &attacker.ReplayConfig{
+		Recording: c.String("recording"),
+		Speed:     c.Float64("speed"),
+	}
+	entries, err := recording.Read(conf.Replay.Recording)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("nothing to replay: %s is empty", conf.Replay.Recording)
+	}
+	attackConf, err := NewAttackConfig(c, conf)
+	if err != nil {
+		return err
+	}
+	zlog.Info(ctx).
+		Str("RUNID", conf.RUNID).
+		Str("recording", conf.Replay.Recording).
+		Int("requests", len(entries)).
+		Stringer("span", entries[len(entries)-1].Offset).
+		Float64("speed", conf.Replay.Speed).
+		Msg("⏯️ Replaying recorded requests")
+	err = attacker.RunReplay(ctx, entries, *conf.Replay, "replay", attackConf)
+	if err != nil {
+		return fmt.Errorf("Error while replaying the recording: %w", err)
+	}
+	zlog.Info(ctx).Stringer("duration", time.Since(startTime)).Msg("Total time taken for completion")
+	return nil
+}
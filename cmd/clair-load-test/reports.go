@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/auth"
+	"github.com/quay/clair-load-test/indexer"
 	"github.com/quay/clair-load-test/manifests"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/clair-load-test/state"
 	"github.com/quay/zlog"
 	"github.com/urfave/cli/v2"
 )
@@ -18,136 +22,260 @@ import (
 // Constants
 var validLayers = []int{-1, 5, 10, 15, 20, 25, 30, 35, 40}
 
+// What to do with pre-seeded manifest hashes clair has no index report for
+const (
+	missingHashesSkip = "skip"
+	missingHashesWarn = "warn"
+)
+
 // Command line to handle reports functionality.
 var ReportsCmd = &cli.Command{
 	Name:        "report",
 	Description: "request reports for named containers",
 	Usage:       "clair-load-test report",
 	Action:      reportAction,
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:    "host",
-			Usage:   "--host localhost:6060/",
-			Value:   "http://localhost:6060/",
-			EnvVars: []string{"CLAIR_TEST_HOST"},
-		},
-		&cli.StringFlag{
-			Name:    "runid",
-			Usage:   "--runid f519d9b2-aa62-44ab-9ce8-4156b712f6d2",
-			Value:   uuid.New().String(),
-			EnvVars: []string{"CLAIR_TEST_RUNID"},
-		},
-		&cli.StringFlag{
-			Name:    "containers",
-			Usage:   "--containers ubuntu:latest,mysql:latest",
-			Value:   "",
-			EnvVars: []string{"CLAIR_TEST_CONTAINERS"},
-		},
-		&cli.StringFlag{
-			Name:    "testrepoprefix",
-			Usage:   "--testrepoprefix quay.io/vchalla/clair-load-test:mysql_8.0.25,quay.io/quay-qetest/clair-load-test:hadoop_latest",
-			Value:   "",
-			EnvVars: []string{"CLAIR_TEST_REPO_PREFIX"},
-		},
-		&cli.StringFlag{
-			Name:    "psk",
-			Usage:   "--psk secretkey",
-			Value:   "",
-			EnvVars: []string{"CLAIR_TEST_PSK"},
-		},
-		&cli.StringFlag{
-			Name:    "eshost",
-			Usage:   "--eshost eshosturl",
-			Value:   "",
-			EnvVars: []string{"CLAIR_TEST_ES_HOST"},
-		},
-		&cli.StringFlag{
-			Name:    "esport",
-			Usage:   "--esport esport",
-			Value:   "",
-			EnvVars: []string{"CLAIR_TEST_ES_PORT"},
-		},
-		&cli.StringFlag{
-			Name:    "esindex",
-			Usage:   "--esindex esindex",
-			Value:   "",
-			EnvVars: []string{"CLAIR_TEST_ES_INDEX"},
-		},
-		&cli.BoolFlag{
-			Name:    "delete",
-			Usage:   "--delete",
-			Value:   false,
-			EnvVars: []string{"CLAIR_TEST_INDEX_REPORT_DELETE"},
-		},
-		&cli.IntFlag{
-			Name:    "hitsize",
-			Usage:   "--hitsize 100",
-			Value:   25,
-			EnvVars: []string{"CLAIR_TEST_HIT_SIZE"},
-		},
-		&cli.IntFlag{
-			Name:    "layers",
-			Usage:   "--layers [-1, 5, 10, 15, 20, 25, 30, 35, 40]",
-			Value:   5,
-			EnvVars: []string{"CLAIR_TEST_LAYERS"},
-			Action: func(ctx *cli.Context, v int) error {
-				for _, layer := range validLayers {
-					if layer == v {
-						return nil
-					}
-				}
-				return fmt.Errorf("Invalid layer value. Must be one among: %v", validLayers)
-			},
-		},
-		&cli.IntFlag{
-			Name:    "concurrency",
-			Usage:   "--concurrency 50",
-			Value:   10,
-			EnvVars: []string{"CLAIR_TEST_CONCURRENCY"},
-		},
-	},
+	Flags: joinFlags(
+		connectionFlags,
+		manifestFlags,
+		indexerFlags,
+		clairFlags,
+		deleteFlags,
+		[]cli.Flag{concurrencyFlag, requestsFlag, durationFlag},
+		transportFlags,
+		pacingFlags,
+		preseededFlags,
+		phaseFlags,
+		soakFlags,
+		authFlags,
+	),
 	Before: func(c *cli.Context) error {
+		if c.Int("concurrency") <= 0 || c.Int("requests") < 0 || c.Duration("duration") < 0 {
+			return fmt.Errorf("--concurrency must be positive, --requests and --duration must not be negative")
+		}
+		if (c.String("arrivals") == attacker.ArrivalsTrace) != (c.String("arrival-trace") != "") {
+			return fmt.Errorf("--arrival-trace goes along with --arrivals %s", attacker.ArrivalsTrace)
+		}
+		if c.String("hashes-file") != "" || c.String("from-runid") != "" {
+			return validatePreseeded(c)
+		}
 		if (c.String("containers") == "" && c.String("testrepoprefix") == "") || ((c.String("containers") != "") && c.String("testrepoprefix") != "") {
 			return fmt.Errorf("Please specify either --containers or --testrepoprefix options. Both are mutually exclusive")
 		}
+		// The index reports left in clair are only found again through the state file
+		if !c.Bool("delete") && !c.IsSet("state-dir") {
+			return fmt.Errorf("Please specify with --state-dir a directory outliving the run, such as a mounted volume, for the cleanup command to find the index reports left without --delete")
+		}
 		return nil
 	},
 }
 
 // Type to store the test config.
 type TestConfig struct {
-	Containers     []string `json:"containers"`
-	Concurrency    int      `json:"concurrency"`
-	TestRepoPrefix []string `json:"testrepoprefix"`
-	ESHost         string   `json:"eshost"`
-	ESPort         string   `json:"esport"`
-	ESIndex        string   `json:"esindex"`
-	Host           string   `json:"host"`
-	HitSize        int      `json:"hitsize"`
-	Layers         int      `json:"layers"`
-	IndexDelete    bool     `json:"delete"`
-	PSK            string   `json:"-"`
-	RUNID          string   `json:"runid"`
+	Containers         []string                     `json:"containers"`
+	Concurrency        int                          `json:"concurrency"`
+	Requests           int                          `json:"requests"`
+	Duration           time.Duration                `json:"duration"`
+	Targeter           string                       `json:"targeter"`
+	Arrivals           string                       `json:"arrivals"`
+	ArrivalTrace       string                       `json:"arrival_trace,omitempty"`
+	TraceSpeed         float64                      `json:"trace_speed,omitempty"`
+	Transport          attacker.Transport           `json:"transport"`
+	TestRepoPrefix     []string                     `json:"testrepoprefix"`
+	Indexer            indexer.Config               `json:"indexer"`
+	Workers            []string                     `json:"workers,omitempty"`
+	WorkerSecret       string                       `json:"-"`
+	SamplesIndex       string                       `json:"samples_index"`
+	SamplesBatch       int                          `json:"samples_batch_size"`
+	SamplesBuffer      int                          `json:"samples_buffer_size"`
+	Host               string                       `json:"host"`
+	ClairMetrics       string                       `json:"clair_metrics_url"`
+	ProxyStats         string                       `json:"proxy_stats_url,omitempty"`
+	ClairVersion       string                       `json:"clair_version"`
+	HitSize            int                          `json:"hitsize"`
+	Layers             int                          `json:"layers"`
+	IndexDelete        bool                         `json:"delete"`
+	HashesFile         string                       `json:"hashes_file,omitempty"`
+	MissingHashes      string                       `json:"missing_hashes,omitempty"`
+	Phases             []string                     `json:"phases"`
+	PhaseRates         map[string]int               `json:"phase_rates,omitempty"`
+	PhaseRequests      map[string]int               `json:"phase_requests,omitempty"`
+	Vulnerabilities    string                       `json:"vulnerabilities,omitempty"`
+	VulnerabilityBatch int                          `json:"vulnerability_batch_size,omitempty"`
+	Session            *attacker.SessionConfig      `json:"session,omitempty"`
+	StateFile          string                       `json:"state_file"`
+	Notifier           *attacker.NotifierConfig     `json:"notifier,omitempty"`
+	Replay             *attacker.ReplayConfig       `json:"replay,omitempty"`
+	Soak               *attacker.SoakConfig         `json:"soak,omitempty"`
+	Availability       *attacker.AvailabilityConfig `json:"availability,omitempty"`
+	Auth               auth.Config                  `json:"auth"`
+	RUNID              string                       `json:"runid"`
+}
+
+// NewBaseConfig creates the part of the test configuration every workload command shares, from
+// the connection, indexer, clair, transport and auth options, reading the secrets given as files.
+// It returns an error if any of the secret files could not be read.
+func NewBaseConfig(c *cli.Context) (*TestConfig, error) {
+	authConf, err := NewAuthConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	esPassword, err := secretOption(c, "es-password", "es-password-file")
+	if err != nil {
+		return nil, err
+	}
+	esAPIKey, err := secretOption(c, "es-api-key", "es-api-key-file")
+	if err != nil {
+		return nil, err
+	}
+	return &TestConfig{
+		Auth:         authConf,
+		RUNID:        c.String("runid"),
+		Host:         c.String("host"),
+		ClairMetrics: c.String("clair-metrics-url"),
+		ProxyStats:   c.String("proxy-stats-url"),
+		ClairVersion: c.String("clair-version"),
+		Transport: attacker.Transport{
+			Timeout:            c.Duration("request-timeout"),
+			KeepAlive:          c.Bool("keepalive"),
+			MaxConnections:     c.Int("max-connections"),
+			IdleConnections:    c.Int("idle-connections"),
+			HTTP2:              c.Bool("http2"),
+			H2C:                c.Bool("h2c"),
+			Redirects:          c.Int("redirects"),
+			Proxy:              c.String("proxy"),
+			CABundle:           c.String("ca-bundle"),
+			ClientCert:         c.String("client-cert"),
+			ClientKey:          c.String("client-key"),
+			InsecureSkipVerify: c.Bool("insecure-skip-verify"),
+			LocalAddr:          c.String("local-addr"),
+		},
+		SamplesIndex:  c.String("samples-index"),
+		SamplesBatch:  c.Int("samples-batch-size"),
+		SamplesBuffer: c.Int("samples-buffer-size"),
+		Indexer: indexer.Config{
+			Type:               c.String("indexer"),
+			URL:                esURL(c),
+			Index:              c.String("esindex"),
+			Username:           c.String("es-username"),
+			Password:           esPassword,
+			APIKey:             esAPIKey,
+			CABundle:           c.String("es-ca-bundle"),
+			InsecureSkipVerify: esInsecureSkipVerify(c),
+			MetricsDirectory:   c.String("metrics-directory"),
+		},
+	}, nil
 }
 
-// NewConfig creates and returns a test configuration from CLI options.
-func NewConfig(c *cli.Context) *TestConfig {
+// NewConfig creates and returns the test configuration of the report and coordinator commands
+// from CLI options, reading the secrets given as files.
+// It returns an error if any of the secret files could not be read or any option is malformed.
+func NewConfig(c *cli.Context) (*TestConfig, error) {
+	conf, err := NewBaseConfig(c)
+	if err != nil {
+		return nil, err
+	}
 	containersArg := c.String("containers")
 	testRepoPrefixArg := c.String("testrepoprefix")
-	return &TestConfig{
-		Containers:     strings.Split(strings.TrimSpace(containersArg), ","),
-		TestRepoPrefix: strings.Split(strings.TrimSpace(testRepoPrefixArg), ","),
-		PSK:            c.String("psk"),
-		RUNID:          c.String("runid"),
-		Host:           c.String("host"),
-		IndexDelete:    c.Bool("delete"),
-		HitSize:        c.Int("hitsize"),
-		Layers:         c.Int("layers"),
-		Concurrency:    c.Int("concurrency"),
-		ESHost:         c.String("eshost"),
-		ESPort:         c.String("esport"),
-		ESIndex:        c.String("esindex"),
+	workerSecret, err := secretOption(c, "worker-secret", "worker-secret-file")
+	if err != nil {
+		return nil, err
 	}
+	phaseRates, err := parsePhaseValues(c.String("phase-rates"))
+	if err != nil {
+		return nil, err
+	}
+	phaseRequests, err := parsePhaseValues(c.String("phase-requests"))
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := parseBuckets(c.String("session-buckets"))
+	if err != nil {
+		return nil, err
+	}
+	phases := selectPhases(splitList(c.String("phases")), c.Bool("delete"))
+	var session *attacker.SessionConfig
+	for _, p := range phases {
+		if p == phaseSession {
+			session = &attacker.SessionConfig{
+				PollInterval: c.Duration("session-poll-interval"),
+				PollTimeout:  c.Duration("session-poll-timeout"),
+				Retries:      c.Int("session-retries"),
+				RetryBackoff: c.Duration("session-retry-backoff"),
+				Buckets:      buckets,
+			}
+		}
+	}
+	var soak *attacker.SoakConfig
+	if c.Duration("soak-duration") > 0 {
+		soak = &attacker.SoakConfig{
+			Duration:     c.Duration("soak-duration"),
+			Window:       c.Duration("soak-window"),
+			LatencyTrend: c.Float64("soak-latency-trend"),
+			ErrorTrend:   c.Float64("soak-error-trend"),
+		}
+	}
+	conf.Containers = strings.Split(strings.TrimSpace(containersArg), ",")
+	conf.TestRepoPrefix = strings.Split(strings.TrimSpace(testRepoPrefixArg), ",")
+	conf.IndexDelete = c.Bool("delete")
+	conf.Phases = phases
+	conf.Session = session
+	conf.Soak = soak
+	conf.HashesFile = hashesFile(c)
+	conf.MissingHashes = c.String("missing-hashes")
+	conf.PhaseRates = phaseRates
+	conf.PhaseRequests = phaseRequests
+	conf.Vulnerabilities = c.String("vulnerabilities")
+	conf.VulnerabilityBatch = c.Int("vulnerability-batch-size")
+	conf.StateFile = state.Path(c.String("state-dir"), c.String("runid"))
+	conf.HitSize = c.Int("hitsize")
+	conf.Layers = c.Int("layers")
+	conf.Concurrency = c.Int("concurrency")
+	conf.Requests = c.Int("requests")
+	conf.Duration = c.Duration("duration")
+	conf.Targeter = c.String("targeter")
+	conf.Arrivals = c.String("arrivals")
+	conf.ArrivalTrace = c.String("arrival-trace")
+	conf.TraceSpeed = c.Float64("trace-speed")
+	conf.Workers = splitList(c.String("workers"))
+	conf.WorkerSecret = workerSecret
+	return conf, nil
+}
+
+// splitList splits a comma separated option, dropping empty entries.
+// It returns a list of strings.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// esURL works out the indexer URL, falling back to the deprecated --eshost and --esport options.
+// It returns the URL string.
+func esURL(c *cli.Context) string {
+	if c.String("es-url") != "" {
+		return c.String("es-url")
+	}
+	if c.String("eshost") != "" && c.String("esport") != "" {
+		zlog.Warn(c.Context).Msg("--eshost and --esport are deprecated, use --es-url instead")
+		return c.String("eshost") + ":" + c.String("esport")
+	}
+	return ""
+}
+
+// esInsecureSkipVerify tells whether to skip the verification of the indexer certificates.
+// The deprecated --eshost and --esport options never verified them, runs still relying on them
+// keep doing so unless --es-insecure-skip-verify says otherwise.
+// It returns a boolean.
+func esInsecureSkipVerify(c *cli.Context) bool {
+	if c.IsSet("es-insecure-skip-verify") || c.String("es-url") != "" || c.String("eshost") == "" || c.String("esport") == "" {
+		return c.Bool("es-insecure-skip-verify")
+	}
+	zlog.Warn(c.Context).Msg("Certificates are not verified with --eshost and --esport, use --es-url to verify them")
+	return true
 }
 
 // calculateLayers calculates the layers number used while fetching images.
@@ -192,28 +320,193 @@ func getContainersList(ctx context.Context, testRepoPrefix []string, hitSize, la
 	return containers
 }
 
+// hashesFile works out where pre-seeded manifest hashes are read from, if anywhere.
+// It returns the path of the hashes or state file, empty when manifests are fetched.
+func hashesFile(c *cli.Context) string {
+	if c.String("hashes-file") != "" {
+		return c.String("hashes-file")
+	}
+	if c.String("from-runid") != "" {
+		return state.Path(c.String("state-dir"), c.String("from-runid"))
+	}
+	return ""
+}
+
+// validatePreseeded makes sure the options make sense for a run against pre-seeded manifests.
+// It returns an error if any of the options conflicts with it.
+func validatePreseeded(c *cli.Context) error {
+	if c.String("hashes-file") != "" && c.String("from-runid") != "" {
+		return fmt.Errorf("Please specify either --hashes-file or --from-runid options. Both are mutually exclusive")
+	}
+	if c.String("containers") != "" || c.String("testrepoprefix") != "" {
+		return fmt.Errorf("--containers and --testrepoprefix cannot be used with pre-seeded manifest hashes")
+	}
+	if c.Bool("delete") {
+		return fmt.Errorf("--delete cannot be used with pre-seeded manifest hashes, use the cleanup command instead")
+	}
+	for _, p := range splitList(c.String("phases")) {
+		if (p == phasePostIndexReport || p == phaseSession) && c.IsSet("phases") {
+			return fmt.Errorf("%s cannot run with pre-seeded manifest hashes, there are no manifests to post", p)
+		}
+	}
+	return nil
+}
+
+// preseededHashes reads the manifest hashes of an earlier run, up to limit of them when positive,
+// and checks that clair still has their index reports, skipping or warning about the ones it does not.
+// It returns the hashes to run against and an error if none is usable.
+func preseededHashes(ctx context.Context, limit int, conf *TestConfig, attackConf *attacker.AttackConfig) ([]string, error) {
+	hashes, err := state.LoadHashes(conf.HashesFile)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+	zlog.Info(ctx).Str("file", conf.HashesFile).Int("manifests", len(hashes)).Msg("Checking the index reports of pre-seeded manifests")
+	missing, err := attacker.CheckIndexReports(ctx, hashes, attackConf)
+	if err != nil {
+		return nil, fmt.Errorf("could not check index reports: %w", err)
+	}
+	if len(missing) == 0 {
+		return hashes, nil
+	}
+	for _, h := range missing {
+		zlog.Warn(ctx).Str("manifest_hash", h).Msg("no index report found")
+	}
+	if conf.MissingHashes == missingHashesWarn {
+		zlog.Warn(ctx).Int("missing", len(missing)).Int("manifests", len(hashes)).Msg("Running against manifests without index reports")
+		return hashes, nil
+	}
+	gone := make(map[string]bool, len(missing))
+	for _, h := range missing {
+		gone[h] = true
+	}
+	var kept []string
+	for _, h := range hashes {
+		if !gone[h] {
+			kept = append(kept, h)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("none of the %d manifests in %s has an index report", len(hashes), conf.HashesFile)
+	}
+	zlog.Warn(ctx).Int("skipped", len(missing)).Int("manifests", len(kept)).Msg("Skipping manifests without index reports")
+	return kept, nil
+}
+
+// NewAttackConfig sets up everything the attacks of a run share: checks the transport
+// and the workers, creates the indexers and the token issuer.
+// It returns the attack configuration and an error if any of them could not be set up.
+func NewAttackConfig(c *cli.Context, conf *TestConfig) (*attacker.AttackConfig, error) {
+	ctx := c.Context
+	var err error
+	attackConf := &attacker.AttackConfig{
+		RUNID:             conf.RUNID,
+		Concurrency:       conf.Concurrency,
+		Host:              conf.Host,
+		ClairMetricsURL:   conf.ClairMetrics,
+		ProxyStatsURL:     conf.ProxyStats,
+		ToolVersion:       c.App.Version,
+		ClairVersion:      conf.ClairVersion,
+		TestConfig:        conf,
+		SamplesBatchSize:  conf.SamplesBatch,
+		SamplesBufferSize: conf.SamplesBuffer,
+		Workers:           conf.Workers,
+		WorkerSecret:      conf.WorkerSecret,
+		Requests:          uint64(conf.Requests),
+		Duration:          conf.Duration,
+		Targeter:          conf.Targeter,
+		Arrivals:          conf.Arrivals,
+		Transport:         conf.Transport,
+	}
+	if err := conf.Transport.Validate(); err != nil {
+		return nil, err
+	}
+	if conf.ArrivalTrace != "" {
+		if attackConf.Trace, err = attacker.ReadTrace(conf.ArrivalTrace); err != nil {
+			return nil, err
+		}
+		attackConf.TraceSpeed = conf.TraceSpeed
+	}
+	if len(conf.Workers) > 0 {
+		if err := attacker.CheckWorkerPSK(conf.Workers, conf.Auth.PSK); err != nil {
+			return nil, err
+		}
+		if err := attacker.CheckWorkers(ctx, conf.Workers, conf.WorkerSecret); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Indexer.Enabled() {
+		attackConf.Indexer, err = indexer.New(ctx, conf.Indexer)
+		if err != nil {
+			return nil, fmt.Errorf("could not create indexer: %w", err)
+		}
+		if conf.SamplesIndex != "" {
+			samplesConf := conf.Indexer
+			samplesConf.Index = conf.SamplesIndex
+			attackConf.Samples, err = indexer.New(ctx, samplesConf)
+			if err != nil {
+				return nil, fmt.Errorf("could not create samples indexer: %w", err)
+			}
+		}
+	}
+	attackConf.Auth, err = auth.NewIssuer(conf.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up authentication: %w", err)
+	}
+	return attackConf, nil
+}
+
 // reportAction drives the report action logic.
 // It returns an error if any during the execution.
 func reportAction(c *cli.Context) error {
 	startTime := time.Now()
 	ctx := c.Context
-	conf := NewConfig(c)
+	conf, err := NewConfig(c)
+	if err != nil {
+		return err
+	}
+	redact.Add(conf.Auth.PSK, conf.Indexer.Password, conf.Indexer.APIKey, conf.WorkerSecret)
+	if conf.HashesFile != "" {
+		conf.Containers, conf.TestRepoPrefix = nil, nil
+		if !c.IsSet("phases") {
+			conf.Phases = []string{phaseGetVulnerabilityReport}
+		}
+	}
 	if c.String("testrepoprefix") != "" {
 		conf.Containers = getContainersList(ctx, conf.TestRepoPrefix, conf.HitSize, conf.Layers, validLayers)
 	}
 	if len(conf.Containers) > conf.HitSize {
 		conf.Containers = conf.Containers[:conf.HitSize]
 	}
-	jwt_token, err := CreateToken(conf.PSK)
+	attackConf, err := NewAttackConfig(c, conf)
+	if err != nil {
+		return err
+	}
+	jwt_token, err := attackConf.Auth.Token()
 	if err != nil {
-		zlog.Debug(ctx).Str("PSK", conf.PSK).Msg("creating token")
 		return fmt.Errorf("could not create token: %w", err)
 	}
 
-	zlog.Debug(ctx).Msg("Fetching manifests for an actual workload")
-	listOfManifests, listOfManifestHashes := manifests.GetManifest(ctx, conf.Containers, conf.Concurrency)
+	var listOfManifests [][]byte
+	var listOfManifestHashes []string
+	if conf.HashesFile != "" {
+		// Every pre-seeded manifest is used unless --hitsize is given
+		var limit int
+		if c.IsSet("hitsize") {
+			limit = conf.HitSize
+		}
+		listOfManifestHashes, err = preseededHashes(ctx, limit, conf, attackConf)
+		if err != nil {
+			return err
+		}
+	} else {
+		zlog.Debug(ctx).Msg("Fetching manifests for an actual workload")
+		listOfManifests, listOfManifestHashes = manifests.GetManifest(ctx, conf.Containers, conf.Concurrency)
+	}
 	zlog.Info(ctx).Msg("🔥 Orchestrating the workload")
-	err = orchestrateWorkload(ctx, listOfManifests, listOfManifestHashes, jwt_token, conf)
+	err = orchestrateWorkload(ctx, listOfManifests, listOfManifestHashes, jwt_token, conf, attackConf)
 	if err != nil {
 		return err
 	}
@@ -223,52 +516,115 @@ func reportAction(c *cli.Context) error {
 	return nil
 }
 
-// orchestrateWorkload triggers the api endpoint hits and writes results to the desired location.
+// orchestrateWorkload runs the selected phases in order and writes results to the desired location.
+// With --soak-duration the phases start over until the duration is reached, the phase running then
+// being cut short.
+// Whatever the outcome, with --delete the index reports created by the run are deleted before returning.
 // It returns an error if any during the execution.
-func orchestrateWorkload(ctx context.Context, manifests [][]byte, manifestHashes []string, jwt_token string, conf *TestConfig) error {
-	zlog.Info(ctx).Str("RUNID", conf.RUNID).Msg("Run details")
-	var requests []map[string]interface{}
-	var err error
-	attackMap := map[string]string{
-		"RUNID":       conf.RUNID,
-		"Concurrency": strconv.Itoa(conf.Concurrency),
-		"ESHost":      conf.ESHost,
-		"ESPort":      conf.ESPort,
-		"ESIndex":     conf.ESIndex,
-		"Host":        conf.Host,
-	}
-	requests = attacker.CreateIndexReportRequests(ctx, manifests, conf.Host, jwt_token)
-	err = attacker.RunVegeta(ctx, requests, "post_index_report", attackMap)
-	if err != nil {
-		return fmt.Errorf("Error while running POST operation on index_report: %w", err)
-	}
+func orchestrateWorkload(ctx context.Context, manifests [][]byte, manifestHashes []string, jwt_token string, conf *TestConfig, attackConf *attacker.AttackConfig) (err error) {
+	zlog.Info(ctx).Str("RUNID", conf.RUNID).Strs("phases", conf.Phases).Msg("Run details")
+	var posted, deleted bool
+	defer func() {
+		if err == nil || !posted || deleted {
+			return
+		}
+		if !conf.IndexDelete {
+			zlog.Warn(ctx).Str("state_file", conf.StateFile).Msg("Index reports are left in clair, use the cleanup command to delete them")
+			return
+		}
+		// Failed and interrupted runs clean up after themselves too
+		if cerr := deleteLeftovers(attacker.Detach(ctx), manifestHashes, conf.StateFile, attackConf); cerr != nil {
+			zlog.Error(ctx).Err(cerr).Str("state_file", conf.StateFile).Msg("could not clean up index reports")
+		}
+	}()
 
-	requests = attacker.GetIndexReportRequests(ctx, manifestHashes, conf.Host, jwt_token)
-	err = attacker.RunVegeta(ctx, requests, "get_index_report", attackMap)
-	if err != nil {
-		return fmt.Errorf("Error while running GET operation on index_report: %w", err)
+	if conf.Soak != nil {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		timer := time.AfterFunc(conf.Soak.Duration, func() { cancel(attacker.ErrSoakOver) })
+		defer func() {
+			timer.Stop()
+			cancel(nil)
+		}()
+		attackConf.Soak = attacker.StartSoak(ctx, *conf.Soak, attackConf)
+		defer attackConf.Soak.Close()
+		zlog.Info(ctx).Stringer("duration", conf.Soak.Duration).Stringer("window", conf.Soak.Window).Msg("🧪 Soaking")
 	}
-
-	requests = attacker.GetVulnerabilityReportRequests(ctx, manifestHashes, conf.Host, jwt_token)
-	err = attacker.RunVegeta(ctx, requests, "get_vulnerability_report", attackMap)
-	if err != nil {
-		return fmt.Errorf("Error while running GET operation on vulnerability_report: %w", err)
+	for {
+		if attackConf.Soak != nil {
+			attackConf.Soak.NextCycle()
+		}
+		err = runCycle(ctx, manifests, manifestHashes, jwt_token, conf, attackConf, &posted, &deleted)
+		if err != nil || attackConf.Soak == nil {
+			break
+		}
+	}
+	if attackConf.Soak != nil && errors.Is(err, attacker.ErrSoakOver) {
+		zlog.Info(ctx).Msg("Soak duration reached")
+		err = nil
+		if conf.IndexDelete && posted && !deleted {
+			// The cycle was cut short before its delete phase
+			if err = deleteLeftovers(attacker.Detach(ctx), manifestHashes, conf.StateFile, attackConf); err != nil {
+				return err
+			}
+			deleted = true
+		}
 	}
-
-	requests = attacker.GetIndexerStateRequests(ctx, len(manifests), conf.Host, jwt_token)
-	err = attacker.RunVegeta(ctx, requests, "get_indexer_state", attackMap)
 	if err != nil {
-		return fmt.Errorf("Error while running GET operation on indexer_state: %w", err)
+		return err
+	}
+	if posted && !deleted {
+		zlog.Info(ctx).Str("state_file", conf.StateFile).Msg("Index reports are left in clair, use the cleanup command to delete them")
 	}
 
-	if conf.IndexDelete {
-		requests = attacker.DeleteIndexReportsRequests(ctx, manifestHashes, conf.Host, jwt_token)
-		err = attacker.RunVegeta(ctx, requests, "delete_index_report", attackMap)
+	zlog.Info(ctx).Str("RUNID", conf.RUNID).Msg("👋 Exiting clair-load-test")
+	return nil
+}
+
+// runCycle runs the selected phases once, in order, keeping track of whether the index reports
+// were posted and deleted. Index reports posted again after a deletion are tracked again.
+// It returns an error if any during the execution.
+func runCycle(ctx context.Context, manifests [][]byte, manifestHashes []string, jwt_token string, conf *TestConfig, attackConf *attacker.AttackConfig, posted, deleted *bool) (err error) {
+	for _, phase := range conf.Phases {
+		if (phase == phasePostIndexReport || phase == phaseSession) && (!*posted || *deleted) {
+			// Remember what is about to be created before creating it, so that nothing goes untracked
+			err = state.Save(conf.StateFile, &state.State{
+				RunID:          conf.RUNID,
+				Host:           conf.Host,
+				CreatedAt:      time.Now().UTC(),
+				ManifestHashes: manifestHashes,
+			})
+			if err != nil {
+				return err
+			}
+			zlog.Info(ctx).Str("state_file", conf.StateFile).Int("manifests", len(manifestHashes)).Msg("Saved run state")
+			*posted, *deleted = true, false
+		}
+		if phase == phaseSession {
+			err = attacker.RunSessions(ctx, manifests, manifestHashes, *conf.Session, phase, phaseConf(attackConf, conf, phase))
+		} else {
+			var requests []map[string]interface{}
+			requests, err = phaseRequests(ctx, phase, manifests, manifestHashes, jwt_token, conf, attackConf)
+			if err != nil {
+				return fmt.Errorf("Error while preparing %s: %w", phaseOperations[phase], err)
+			}
+			if phase == phaseDeleteIndexReport {
+				var left []string
+				left, err = attacker.RunDeletions(ctx, requests, manifestHashes, phase, phaseConf(attackConf, conf, phase))
+				if err == nil && *posted {
+					// Only sweep the index reports the measured phase did not delete
+					if err = deleteLeftovers(ctx, left, conf.StateFile, attackConf); err != nil {
+						return err
+					}
+					*deleted = true
+				}
+			} else {
+				err = attacker.RunVegeta(ctx, requests, phase, phaseConf(attackConf, conf, phase))
+			}
+		}
 		if err != nil {
-			return fmt.Errorf("Error while running DELETE operation on index_report: %w", err)
+			return fmt.Errorf("Error while running %s: %w", phaseOperations[phase], err)
 		}
 	}
-
-	zlog.Info(ctx).Str("RUNID", conf.RUNID).Msg("👋 Exiting clair-load-test")
 	return nil
 }
@@ -1,25 +1,23 @@
 package main
 
 import (
-	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/quay/zlog"
+	"github.com/quay/clair-load-test/auth"
 	"github.com/urfave/cli/v2"
 	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
-// Constants defined here.
-const (
-	TokenIssuer         = "clairctl"
-	TokenValidityPeriod = time.Hour * 24 * 7
-)
-
 // CreateTokenCmd handles createtoken CLI.
 var CreateTokenCmd = &cli.Command{
 	Name:        "createtoken",
-	Description: "Creates a JWT token given a psk",
+	Description: "Creates a JWT token given a psk, kept for compatibility: see token create",
 	Usage:       "createtoken --key sdfvevefr==",
 	Action:      createTokenAction,
 	Flags: []cli.Flag{
@@ -29,45 +27,237 @@ var CreateTokenCmd = &cli.Command{
 			Value:   "",
 			EnvVars: []string{"PSK_KEY"},
 		},
+		tokenKeyFileFlag,
 	},
 }
 
 // createTokenAction to drive token action from the CLI options.
 // It returns an error if any during the execution.
+// The token is printed on stdout, logs never carry it.
 func createTokenAction(c *cli.Context) error {
-	ctx := c.Context
-	key := c.String("key")
-	zlog.Debug(ctx).Str("key", key).Msg("got md5 key")
+	key, err := secretOption(c, "key", "key-file")
+	if err != nil {
+		return err
+	}
 	tok, err := CreateToken(key)
 	if err != nil {
 		return err
 	}
-	zlog.Info(ctx).Msg(tok)
+	fmt.Fprintln(c.App.Writer, tok)
 	return nil
 }
 
 // CreateToken creates a token from the input PSK key.
 // It returns a token string and an error if any during the execution.
 func CreateToken(key string) (tok string, err error) {
-	decKey, err := base64.StdEncoding.DecodeString(key)
+	s, err := auth.NewPSKSigner(key)
 	if err != nil {
 		return "", err
 	}
-	sk := jose.SigningKey{
-		Algorithm: jose.HS256,
-		Key:       decKey,
+	return auth.Mint(s, auth.Config{}, time.Now())
+}
+
+// tokenKeyFlag is the PSK used to sign and verify tokens.
+var tokenKeyFlag = &cli.StringFlag{
+	Name:    "key",
+	Usage:   "--key ddsdfsdfsfsd==",
+	Value:   "",
+	EnvVars: []string{"PSK_KEY", "CLAIR_TEST_PSK"},
+}
+
+// tokenKeyFileFlag points at a file holding the PSK, such as a mounted Kubernetes secret.
+var tokenKeyFileFlag = &cli.StringFlag{
+	Name:    "key-file",
+	Usage:   "--key-file /var/run/secrets/clair/psk",
+	Value:   "",
+	EnvVars: []string{"PSK_KEY_FILE", "CLAIR_TEST_PSK_FILE"},
+}
+
+// tokenInputFlag points at a file holding the token to inspect, such as a mounted Kubernetes secret.
+var tokenInputFlag = &cli.StringFlag{
+	Name:    "token-file",
+	Usage:   "--token-file /var/run/secrets/clair/token",
+	Value:   "",
+	EnvVars: []string{"CLAIR_TEST_TOKEN_FILE"},
+}
+
+// TokenCmd handles the token CLI.
+var TokenCmd = &cli.Command{
+	Name:        "token",
+	Description: "Creates, decodes and verifies the JWT tokens used against clair",
+	Usage:       "token [create, decode, verify]",
+	Subcommands: []*cli.Command{
+		{
+			Name:        "create",
+			Description: "Creates a JWT token signed with a psk or a private key",
+			Usage:       "token create --key sdfvevefr== --token-validity 1h",
+			Action:      tokenCreateAction,
+			Flags: append([]cli.Flag{
+				tokenKeyFlag,
+				tokenKeyFileFlag,
+				&cli.StringFlag{
+					Name:    "signing-key",
+					Usage:   "--signing-key /var/run/secrets/clair/key.pem",
+					Value:   "",
+					EnvVars: []string{"CLAIR_TEST_SIGNING_KEY"},
+				},
+				&cli.StringFlag{
+					Name:    "key-id",
+					Usage:   "--key-id 7d5f0c3e",
+					Value:   "",
+					EnvVars: []string{"CLAIR_TEST_KEY_ID"},
+				},
+			}, claimFlags...),
+		},
+		{
+			Name:        "decode",
+			Description: "Prints the header and the claims of a token, given as argument or on stdin",
+			Usage:       "token decode eyJhbGciOi...",
+			Action:      tokenDecodeAction,
+			Flags:       []cli.Flag{tokenInputFlag},
+		},
+		{
+			Name:        "verify",
+			Description: "Checks a token, given as argument or on stdin, against a psk and the expected claims",
+			Usage:       "token verify --key sdfvevefr== eyJhbGciOi...",
+			Action:      tokenVerifyAction,
+			Flags: append([]cli.Flag{
+				tokenKeyFlag,
+				tokenKeyFileFlag,
+				tokenInputFlag,
+				&cli.DurationFlag{
+					Name:    "leeway",
+					Usage:   "--leeway 1m",
+					Value:   jwt.DefaultLeeway,
+					EnvVars: []string{"CLAIR_TEST_TOKEN_LEEWAY"},
+				},
+			}, claimFlags[:3]...),
+		},
+	},
+}
+
+// tokenCreateAction mints a token with the claim options and prints it.
+// It returns an error if any during the execution.
+func tokenCreateAction(c *cli.Context) error {
+	key, err := secretOption(c, "key", "key-file")
+	if err != nil {
+		return err
+	}
+	conf := auth.Config{
+		Mode:     auth.ModePSK,
+		PSK:      key,
+		Issuer:   c.String("token-issuer"),
+		Audience: splitList(c.String("token-audience")),
+		Subject:  c.String("token-subject"),
+		Validity: c.Duration("token-validity"),
+		KeyFile:  c.String("signing-key"),
+		KeyID:    c.String("key-id"),
+	}
+	var s jose.Signer
+	switch {
+	case conf.KeyFile != "":
+		s, err = auth.NewKeySigner(conf.KeyFile, conf.KeyID)
+	case conf.PSK != "":
+		s, err = auth.NewPSKSigner(conf.PSK)
+	default:
+		return fmt.Errorf("token create needs a --key or a --signing-key")
 	}
-	s, err := jose.NewSigner(sk, nil)
 	if err != nil {
-		return "", err
+		return err
+	}
+	tok, err := auth.Mint(s, conf, time.Now())
+	if err != nil {
+		return err
 	}
-	now := time.Now()
+	fmt.Fprintln(c.App.Writer, tok)
+	return nil
+}
 
-	// Mint the jwt.
-	return jwt.Signed(s).Claims(&jwt.Claims{
-		Issuer:    TokenIssuer,
-		Expiry:    jwt.NewNumericDate(now.Add(TokenValidityPeriod)),
-		IssuedAt:  jwt.NewNumericDate(now),
-		NotBefore: jwt.NewNumericDate(now),
-	}).CompactSerialize()
+// tokenDecodeAction prints the header and the claims of a token, with readable timestamps.
+// It returns an error if any during the execution.
+func tokenDecodeAction(c *cli.Context) error {
+	tok, err := readToken(c)
+	if err != nil {
+		return err
+	}
+	header, claims, err := auth.Decode(tok)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(map[string]interface{}{
+		"header": header,
+		"claims": claims,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.App.Writer, string(out))
+	for _, k := range []string{"iat", "nbf", "exp"} {
+		n, ok := claims[k].(json.Number)
+		if !ok {
+			continue
+		}
+		secs, err := n.Int64()
+		if err != nil {
+			continue
+		}
+		t := time.Unix(secs, 0).UTC()
+		fmt.Fprintf(c.App.Writer, "%s: %s (%s)\n", k, t.Format(time.RFC3339), time.Until(t).Round(time.Second))
+	}
+	return nil
+}
+
+// tokenVerifyAction checks a token against the PSK and the expected claims and prints the problems found.
+// It returns an error if the token would be rejected.
+func tokenVerifyAction(c *cli.Context) error {
+	tok, err := readToken(c)
+	if err != nil {
+		return err
+	}
+	key, err := secretOption(c, "key", "key-file")
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("token verify needs a --key or a --key-file")
+	}
+	expected := auth.Config{
+		Issuer:   c.String("token-issuer"),
+		Audience: splitList(c.String("token-audience")),
+		Subject:  c.String("token-subject"),
+	}
+	problems, err := auth.Verify(tok, key, expected, c.Duration("leeway"), time.Now())
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(c.App.Writer, "token is valid")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintln(c.App.Writer, "- "+p)
+	}
+	return fmt.Errorf("token is not valid: %d problem(s) found", len(problems))
+}
+
+// readToken reads the token from the token file, the first argument, or from stdin when both are missing
+// or the argument is "-".
+// It returns the token and an error if none was given.
+func readToken(c *cli.Context) (string, error) {
+	if c.String("token-file") != "" {
+		return secretOption(c, "", "token-file")
+	}
+	tok := c.Args().First()
+	if tok == "" || tok == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("could not read token from stdin: %w", err)
+		}
+		tok = string(b)
+	}
+	tok = strings.TrimSpace(tok)
+	if tok == "" {
+		return "", fmt.Errorf("no token given")
+	}
+	return tok, nil
 }
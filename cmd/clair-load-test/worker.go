@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quay/clair-load-test/attacker"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/zlog"
+	"github.com/urfave/cli/v2"
+)
+
+// WorkerCmd serves attacks on behalf of a coordinator.
+var WorkerCmd = &cli.Command{
+	Name:        "worker",
+	Description: "generate load on behalf of a clair-load-test coordinator",
+	Usage:       "clair-load-test worker --listen 127.0.0.1:8080 --secret-file /var/run/secrets/clair-load-test/worker",
+	Action:      workerAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "listen",
+			Usage:   "--listen 127.0.0.1:8080",
+			Value:   "127.0.0.1:8080",
+			EnvVars: []string{"CLAIR_TEST_WORKER_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "secret",
+			Usage:   "--secret sharedsecret",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_WORKER_SECRET"},
+		},
+		&cli.StringFlag{
+			Name:    "secret-file",
+			Usage:   "--secret-file /var/run/secrets/clair-load-test/worker",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_WORKER_SECRET_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-cert",
+			Usage:   "--tls-cert /var/run/secrets/clair-load-test/tls.crt",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_WORKER_TLS_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-key",
+			Usage:   "--tls-key /var/run/secrets/clair-load-test/tls.key",
+			Value:   "",
+			EnvVars: []string{"CLAIR_TEST_WORKER_TLS_KEY"},
+		},
+	},
+	Before: func(c *cli.Context) error {
+		if c.String("secret") == "" && c.String("secret-file") == "" {
+			return fmt.Errorf("Please specify the secret shared with the coordinator with --secret or --secret-file")
+		}
+		if (c.String("tls-cert") == "") != (c.String("tls-key") == "") {
+			return fmt.Errorf("Please specify both --tls-cert and --tls-key to serve the worker over TLS")
+		}
+		return nil
+	},
+}
+
+// workerAction serves the worker endpoints until the context is cancelled.
+// It returns an error if any during the execution.
+func workerAction(c *cli.Context) error {
+	ctx := c.Context
+	secret, err := secretOption(c, "secret", "secret-file")
+	if err != nil {
+		return err
+	}
+	redact.Add(secret)
+	srv := &http.Server{
+		Addr:    c.String("listen"),
+		Handler: attacker.NewWorker(secret),
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	cert, key := c.String("tls-cert"), c.String("tls-key")
+	zlog.Info(ctx).Str("listen", srv.Addr).Bool("tls", cert != "").Msg("👷 Worker waiting for jobs")
+	if cert != "" {
+		err = srv.ListenAndServeTLS(cert, key)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package faultproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// Constants
+const (
+	dialTimeout = 30 * time.Second
+	// throttleSlices is how many writes a second of throttled bandwidth is split into
+	throttleSlices = 10
+)
+
+// Type used to forward requests to clair, or to any host, injecting faults on the way.
+type Proxy struct {
+	conf     Config
+	upstream *url.URL
+	reverse  *httputil.ReverseProxy
+	start    time.Time
+	// counters has one entry per rule, then one for the default faults
+	counters []*counters
+}
+
+// Type used to count the faults a rule injected, safe for concurrent use.
+type counters struct {
+	requests, delayed, delay, resets, errors, throttled, bytesIn, bytesOut atomic.Uint64
+}
+
+// New creates a fault injecting proxy.
+// It returns the proxy and an error if the configuration is not valid.
+func New(c Config) (*Proxy, error) {
+	if err := c.Default.validate(); err != nil {
+		return nil, err
+	}
+	for _, r := range c.Rules {
+		if _, err := path.Match(r.Pattern, ""); err != nil || r.Pattern == "" {
+			return nil, fmt.Errorf("invalid rule pattern %q", r.Pattern)
+		}
+		if err := r.Faults.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", r.Pattern, err)
+		}
+	}
+	p := &Proxy{conf: c, start: time.Now()}
+	for i := 0; i <= len(c.Rules); i++ {
+		p.counters = append(p.counters, &counters{})
+	}
+	if c.Upstream == "" {
+		if len(c.Forward) == 0 {
+			return nil, fmt.Errorf("no upstream nor host to forward to: the proxy must not be open")
+		}
+		for _, h := range c.Forward {
+			if _, err := path.Match(h, ""); err != nil || h == "" {
+				return nil, fmt.Errorf("invalid host to forward to %q", h)
+			}
+		}
+		// Requests carry their destination, HTTP_PROXY style
+		p.reverse = &httputil.ReverseProxy{Director: func(*http.Request) {}}
+		return p, nil
+	}
+	u, err := url.Parse(c.Upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: must look like http://clair:6060", c.Upstream)
+	}
+	if len(c.Forward) != 0 {
+		return nil, fmt.Errorf("hosts to forward to are only used without an upstream")
+	}
+	p.upstream = u
+	p.reverse = httputil.NewSingleHostReverseProxy(u)
+	director := p.reverse.Director
+	p.reverse.Director = func(r *http.Request) {
+		director(r)
+		r.Host = u.Host
+	}
+	return p, nil
+}
+
+// validate makes sure faults can be injected.
+// It returns an error if any of the rates, durations or codes is out of range.
+func (f Faults) validate() error {
+	if f.Latency < 0 || f.Jitter < 0 {
+		return fmt.Errorf("latency and jitter must not be negative")
+	}
+	if f.ResetRate < 0 || f.ResetRate > 1 || f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("reset and error rates must be between 0 and 1")
+	}
+	if f.ErrorCode != 0 && (f.ErrorCode < 100 || f.ErrorCode > 599) {
+		return fmt.Errorf("invalid error code %d", f.ErrorCode)
+	}
+	if f.Bandwidth < 0 {
+		return fmt.Errorf("bandwidth must not be negative")
+	}
+	return nil
+}
+
+// delay draws the latency of a request, jittered either way.
+// It returns the latency, never negative.
+func (f Faults) delay() time.Duration {
+	d := f.Latency
+	if f.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*f.Jitter)+1)) - f.Jitter
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// match looks up the rule a request falls under, by the path of its URL or the host of its tunnel.
+// It returns the index of the rule, the number of rules when it falls under the default faults.
+func (p *Proxy) match(r *http.Request) int {
+	key := r.URL.Path
+	if r.Method == http.MethodConnect {
+		key = r.Host
+	}
+	for i, rule := range p.conf.Rules {
+		if matchPath(rule.Pattern, key) {
+			return i
+		}
+	}
+	return len(p.conf.Rules)
+}
+
+// matchPath tells whether a pattern matches a path or any of its parents, so that
+// /matcher/* matches everything under /matcher.
+func matchPath(pattern, p string) bool {
+	for {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		parent := path.Dir(p)
+		if parent == p || parent == "." {
+			return false
+		}
+		p = parent
+	}
+}
+
+// forwardable tells whether the proxy forwards requests and tunnels to a host, given with or without its port.
+func (p *Proxy) forwardable(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	for _, pattern := range p.conf.Forward {
+		if ok, _ := path.Match(pattern, hostport); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// faults returns the faults of a rule, the default ones past the last rule.
+func (p *Proxy) faults(i int) Faults {
+	if i < len(p.conf.Rules) {
+		return p.conf.Rules[i].Faults
+	}
+	return p.conf.Default
+}
+
+// ServeHTTP forwards a request after delaying it, and either resets its connection,
+// answers it with an error, or throttles its bodies, as its rule says.
+// Without an upstream, only requests to the allowed hosts are forwarded.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.upstream == nil {
+		if r.Method != http.MethodConnect && !r.URL.IsAbs() {
+			http.Error(w, "no upstream configured: send proxy requests", http.StatusBadRequest)
+			return
+		}
+		if !p.forwardable(r.URL.Host) {
+			http.Error(w, "host not allowed by the proxy", http.StatusForbidden)
+			return
+		}
+	}
+	i := p.match(r)
+	f, c := p.faults(i), p.counters[i]
+	c.requests.Add(1)
+	if d := f.delay(); d > 0 {
+		c.delayed.Add(1)
+		c.delay.Add(uint64(d))
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		}
+	}
+	if f.ResetRate > 0 && rand.Float64() < f.ResetRate {
+		c.resets.Add(1)
+		reset(w)
+		return
+	}
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		c.errors.Add(1)
+		code := f.ErrorCode
+		if code == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		http.Error(w, "error injected by the proxy", code)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r, f, c)
+		return
+	}
+	var t *throttle
+	if f.Bandwidth > 0 {
+		c.throttled.Add(1)
+		t = newThrottle(r.Context(), f.Bandwidth)
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &meteredReader{ReadCloser: r.Body, n: &c.bytesIn, t: t}
+	}
+	p.reverse.ServeHTTP(&meteredWriter{ResponseWriter: w, n: &c.bytesOut, t: t}, r)
+}
+
+// reset drops the connection of a request, with a TCP reset when possible.
+func reset(w http.ResponseWriter) {
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+			return
+		}
+	}
+	// HTTP/2 streams are reset when the handler aborts
+	panic(http.ErrAbortHandler)
+}
+
+// tunnel relays a CONNECT request to its host, throttling both ways when asked to.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request, f Faults, c *counters) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunnels need HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	up, err := net.DialTimeout("tcp", r.Host, dialTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		up.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		up.Close()
+		conn.Close()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var in, out *throttle
+	if f.Bandwidth > 0 {
+		c.throttled.Add(1)
+		in, out = newThrottle(ctx, f.Bandwidth), newThrottle(ctx, f.Bandwidth)
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(up, &meteredReader{ReadCloser: io.NopCloser(buf), n: &c.bytesIn, t: in})
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, &meteredReader{ReadCloser: up, n: &c.bytesOut, t: out})
+		done <- struct{}{}
+	}()
+	// Either side closing ends the tunnel
+	<-done
+	cancel()
+	up.Close()
+	conn.Close()
+	<-done
+}
+
+// Type used to hold a stream to a bandwidth.
+type throttle struct {
+	ctx   context.Context
+	bps   int64
+	start time.Time
+	n     int64
+}
+
+// newThrottle creates a throttle for a stream starting now.
+func newThrottle(ctx context.Context, bps int64) *throttle {
+	return &throttle{ctx: ctx, bps: bps, start: time.Now()}
+}
+
+// slice returns how many bytes to move at once, so that the stream flows evenly.
+func (t *throttle) slice() int {
+	n := t.bps / throttleSlices
+	if n < 1 {
+		return 1
+	}
+	return int(n)
+}
+
+// wait accounts for n bytes moved and sleeps until the bandwidth allows for them.
+func (t *throttle) wait(n int) {
+	t.n += int64(n)
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.bps) * float64(time.Second)))
+	d := time.Until(due)
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+	case <-t.ctx.Done():
+		timer.Stop()
+	}
+}
+
+// Type used to count, and throttle when asked to, the bytes read from a body.
+type meteredReader struct {
+	io.ReadCloser
+	n *atomic.Uint64
+	t *throttle
+}
+
+// Read reads from the body, no more than a slice at once when throttled.
+func (m *meteredReader) Read(b []byte) (int, error) {
+	if m.t != nil && len(b) > m.t.slice() {
+		b = b[:m.t.slice()]
+	}
+	n, err := m.ReadCloser.Read(b)
+	m.n.Add(uint64(n))
+	if m.t != nil {
+		m.t.wait(n)
+	}
+	return n, err
+}
+
+// Type used to count, and throttle when asked to, the bytes written to a response.
+type meteredWriter struct {
+	http.ResponseWriter
+	n *atomic.Uint64
+	t *throttle
+}
+
+// Write writes to the response, a slice at a time, flushed, when throttled.
+func (m *meteredWriter) Write(b []byte) (int, error) {
+	if m.t == nil {
+		n, err := m.ResponseWriter.Write(b)
+		m.n.Add(uint64(n))
+		return n, err
+	}
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > m.t.slice() {
+			chunk = chunk[:m.t.slice()]
+		}
+		n, err := m.ResponseWriter.Write(chunk)
+		written += n
+		m.n.Add(uint64(n))
+		if err != nil {
+			return written, err
+		}
+		m.Flush()
+		m.t.wait(n)
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Flush sends what was written so far to the client.
+func (m *meteredWriter) Flush() {
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the response writer being metered.
+func (m *meteredWriter) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
@@ -0,0 +1,53 @@
+package faultproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewOpenProxy(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("a proxy without an upstream nor hosts to forward to must not be created")
+	}
+	if _, err := New(Config{Upstream: "http://clair:6060", Forward: []string{"quay.io"}}); err == nil {
+		t.Error("hosts to forward to must not be given along with an upstream")
+	}
+}
+
+func TestForward(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer registry.Close()
+	u, err := url.Parse(registry.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		forward []string
+		want    int
+	}{
+		{[]string{u.Host}, http.StatusOK},
+		{[]string{u.Hostname()}, http.StatusOK},
+		{[]string{"127.*"}, http.StatusOK},
+		{[]string{"quay.io", "*.quay.io"}, http.StatusForbidden},
+	} {
+		p, err := New(Config{Forward: tc.forward})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(p)
+		proxy, _ := url.Parse(srv.URL)
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+		res, err := client.Get(registry.URL + "/v2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		srv.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("forwarding to %v: got %d, want %d", tc.forward, res.StatusCode, tc.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package faultproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRules parses rules such as "/indexer/*=latency:2s,reset:0.01;/matcher/*=error:0.05:500",
+// each rule starting from the base faults and overriding part of them.
+// It returns the rules, in order, and an error if any of them is malformed.
+func ParseRules(base Faults, s string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, spec, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(pattern) == "" {
+			return nil, fmt.Errorf("invalid rule %q: must look like /indexer/*=latency:2s,reset:0.01", entry)
+		}
+		faults, err := ParseFaults(base, spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", entry, err)
+		}
+		rules = append(rules, Rule{Pattern: strings.TrimSpace(pattern), Faults: faults})
+	}
+	return rules, nil
+}
+
+// ParseFaults parses faults such as "latency:2s,jitter:500ms,reset:0.01,error:0.05:500,bandwidth:65536"
+// on top of the base faults.
+// It returns the faults and an error if any of them is malformed.
+func ParseFaults(base Faults, s string) (Faults, error) {
+	f := base
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(part, ":")
+		if !ok {
+			return Faults{}, fmt.Errorf("invalid fault %q: must look like latency:2s", part)
+		}
+		var err error
+		switch name {
+		case "latency":
+			f.Latency, err = time.ParseDuration(v)
+		case "jitter":
+			f.Jitter, err = time.ParseDuration(v)
+		case "reset":
+			f.ResetRate, err = strconv.ParseFloat(v, 64)
+		case "error":
+			rate, code, hasCode := strings.Cut(v, ":")
+			if f.ErrorRate, err = strconv.ParseFloat(rate, 64); err == nil && hasCode {
+				f.ErrorCode, err = strconv.Atoi(code)
+			}
+		case "bandwidth":
+			f.Bandwidth, err = strconv.ParseInt(v, 10, 64)
+		default:
+			return Faults{}, fmt.Errorf("unknown fault %q: must be one among %v", name, []string{"latency", "jitter", "reset", "error", "bandwidth"})
+		}
+		if err != nil {
+			return Faults{}, fmt.Errorf("invalid fault %q: %w", part, err)
+		}
+	}
+	return f, f.validate()
+}
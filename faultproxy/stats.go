@@ -0,0 +1,111 @@
+package faultproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+// Constants
+const scrapeTimeout = 30 * time.Second
+
+// Stats counts the faults injected since the proxy started, rule by rule, the default faults last.
+// It returns the stats.
+func (p *Proxy) Stats() Stats {
+	s := Stats{Start: p.start, End: time.Now(), Upstream: p.conf.Upstream}
+	for i, c := range p.counters {
+		pattern := DefaultRule
+		if i < len(p.conf.Rules) {
+			pattern = p.conf.Rules[i].Pattern
+		}
+		s.Rules = append(s.Rules, RuleStats{
+			Pattern:   pattern,
+			Faults:    p.faults(i),
+			Requests:  c.requests.Load(),
+			Delayed:   c.delayed.Load(),
+			Delay:     time.Duration(c.delay.Load()),
+			Resets:    c.resets.Load(),
+			Errors:    c.errors.Load(),
+			Throttled: c.throttled.Load(),
+			BytesIn:   c.bytesIn.Load(),
+			BytesOut:  c.bytesOut.Load(),
+		})
+	}
+	return s
+}
+
+// StatsHandler serves the stats of the proxy as JSON, for the runs going through it to record.
+func (p *Proxy) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.Stats())
+	})
+}
+
+// Scrape fetches the stats a proxy exposes.
+// It returns the stats and an error if any during the execution.
+func Scrape(ctx context.Context, url string) (*Stats, error) {
+	zlog.Debug(ctx).Str("url", url).Msg("scraping proxy stats")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: scrapeTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not scrape proxy stats: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status while scraping proxy stats: %s", res.Status)
+	}
+	var s Stats
+	if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("could not decode proxy stats: %w", err)
+	}
+	return &s, nil
+}
+
+// Diff compares two snapshots of the stats taken around a phase.
+// It returns what was injected in between, rule by rule.
+func Diff(before, after *Stats) *Stats {
+	delta := &Stats{Start: before.End, End: after.End, Upstream: after.Upstream, Rules: []RuleStats{}}
+	prev := make(map[string]RuleStats, len(before.Rules))
+	for _, r := range before.Rules {
+		prev[r.Pattern] = r
+	}
+	sub := func(a, b uint64) uint64 {
+		if b > a {
+			return a
+		}
+		return a - b
+	}
+	for _, r := range after.Rules {
+		b := prev[r.Pattern]
+		// A proxy restarted in between counts from zero again
+		if after.Start.After(before.Start) {
+			b = RuleStats{}
+		}
+		delta.Rules = append(delta.Rules, RuleStats{
+			Pattern:   r.Pattern,
+			Faults:    r.Faults,
+			Requests:  sub(r.Requests, b.Requests),
+			Delayed:   sub(r.Delayed, b.Delayed),
+			Delay:     time.Duration(sub(uint64(r.Delay), uint64(b.Delay))),
+			Resets:    sub(r.Resets, b.Resets),
+			Errors:    sub(r.Errors, b.Errors),
+			Throttled: sub(r.Throttled, b.Throttled),
+			BytesIn:   sub(r.BytesIn, b.BytesIn),
+			BytesOut:  sub(r.BytesOut, b.BytesOut),
+		})
+	}
+	return delta
+}
@@ -0,0 +1,63 @@
+package faultproxy
+
+import (
+	"time"
+)
+
+// Name of the rule applied to the requests no other rule matches
+const DefaultRule = "default"
+
+// Type used to describe the faults injected into the requests going through the proxy.
+// Latency is delayed by up to Jitter either way, and Bandwidth is in bytes per second,
+// unlimited when zero.
+type Faults struct {
+	Latency   time.Duration `json:"latency"`
+	Jitter    time.Duration `json:"jitter"`
+	ResetRate float64       `json:"reset_rate"`
+	ErrorRate float64       `json:"error_rate"`
+	ErrorCode int           `json:"error_code"`
+	Bandwidth int64         `json:"bandwidth"`
+}
+
+// Type used to apply faults to the requests whose path, or host for tunnels, matches a pattern.
+// Patterns follow path.Match and also match everything under the paths they match.
+type Rule struct {
+	Pattern string `json:"pattern"`
+	Faults  Faults `json:"faults"`
+}
+
+// Type used to configure the proxy.
+type Config struct {
+	// Upstream is where requests are forwarded to, when empty the proxy forwards
+	// requests to their own host, HTTP_PROXY style, and tunnels CONNECT requests
+	Upstream string `json:"upstream,omitempty"`
+	// Forward lists the hosts the proxy forwards requests and tunnels to when there is no upstream.
+	// Patterns follow path.Match and match the host with or without its port
+	Forward []string `json:"forward,omitempty"`
+	// Default is the faults of the requests no rule matches
+	Default Faults `json:"default"`
+	// Rules are tried in order, the first matching one wins
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// Type used to count the faults a rule injected.
+type RuleStats struct {
+	Pattern   string        `json:"pattern"`
+	Faults    Faults        `json:"faults"`
+	Requests  uint64        `json:"requests"`
+	Delayed   uint64        `json:"delayed"`
+	Delay     time.Duration `json:"delay"`
+	Resets    uint64        `json:"resets"`
+	Errors    uint64        `json:"errors"`
+	Throttled uint64        `json:"throttled"`
+	BytesIn   uint64        `json:"bytes_in"`
+	BytesOut  uint64        `json:"bytes_out"`
+}
+
+// Type used to expose what the proxy injected, rule by rule, over a period of time.
+type Stats struct {
+	Start    time.Time   `json:"start"`
+	End      time.Time   `json:"end"`
+	Upstream string      `json:"upstream,omitempty"`
+	Rules    []RuleStats `json:"rules"`
+}
@@ -4,7 +4,9 @@ go 1.20
 
 require (
 	github.com/cloud-bulldozer/go-commons v1.0.4
+	github.com/elastic/go-elasticsearch/v7 v7.13.1
 	github.com/google/uuid v1.3.0
+	github.com/opensearch-project/opensearch-go v1.1.0
 	github.com/quay/zlog v0.0.0-20210113185248-ce16eed1dcec
 	github.com/rs/zerolog v1.23.0
 	github.com/tsenart/vegeta/v12 v12.11.1
@@ -15,11 +17,9 @@ require (
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
-	github.com/elastic/go-elasticsearch/v7 v7.13.1 // indirect
 	github.com/influxdata/tdigest v0.0.1 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
-	github.com/opensearch-project/opensearch-go v1.1.0 // indirect
 	github.com/rs/dnscache v0.0.0-20211102005908-e0241e321417 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/stretchr/testify v1.8.1 // indirect
@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+)
+
+// Type used to talk to elasticsearch.
+type elastic struct {
+	client *elasticsearch.Client
+}
+
+// newElastic connects to elasticsearch and makes sure the index exists.
+// It returns the indexer and an error if any during the execution.
+func newElastic(ctx context.Context, c Config) (*search, error) {
+	if c.Index == "" {
+		return nil, fmt.Errorf("index name not specified")
+	}
+	transport, caCert, err := newTransport(c)
+	if err != nil {
+		return nil, err
+	}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{c.URL},
+		Username:  c.Username,
+		Password:  c.Password,
+		APIKey:    c.APIKey,
+		CACert:    caCert,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating the elasticsearch client: %w", err)
+	}
+	return newSearch(ctx, "elasticsearch", elastic{client}, c)
+}
+
+// esResponse reads a response of the elasticsearch client.
+// It returns the response and the error of the call.
+func esResponse(r *esapi.Response, err error) (response, error) {
+	if err != nil {
+		return response{}, err
+	}
+	return readResponse(r.StatusCode, r.Body), nil
+}
+
+// health checks the cluster health.
+func (e elastic) health(ctx context.Context) (response, error) {
+	return esResponse(e.client.Cluster.Health(e.client.Cluster.Health.WithContext(ctx)))
+}
+
+// exists looks the index up.
+func (e elastic) exists(ctx context.Context, index string) (response, error) {
+	return esResponse(e.client.Indices.Exists([]string{index}, e.client.Indices.Exists.WithContext(ctx)))
+}
+
+// create creates the index.
+func (e elastic) create(ctx context.Context, index string) (response, error) {
+	return esResponse(e.client.Indices.Create(index, e.client.Indices.Create.WithContext(ctx)))
+}
+
+// bulk starts a bulk indexer writing into the index.
+func (e elastic) bulk(index string) (bulkIndexer, error) {
+	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Client:     e.client,
+		Index:      index,
+		FlushBytes: bulkFlushBytes,
+		NumWorkers: runtime.NumCPU(),
+		Timeout:    bulkTimeout,
+	})
+	return esBulk{bi}, err
+}
+
+// Type used to queue documents into the elasticsearch bulk indexer.
+type esBulk struct {
+	esutil.BulkIndexer
+}
+
+// add queues a document, calling record with the result once it is indexed or failed.
+func (b esBulk) add(ctx context.Context, doc []byte, id string, record func(result string)) error {
+	return b.Add(ctx, esutil.BulkIndexerItem{
+		Action:     "index",
+		Body:       bytes.NewReader(doc),
+		DocumentID: id,
+		OnSuccess: func(_ context.Context, _ esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+			record(res.Result)
+		},
+		OnFailure: func(_ context.Context, _ esutil.BulkIndexerItem, _ esutil.BulkIndexerResponseItem, _ error) {
+			record("failed")
+		},
+	})
+}
+
+// close flushes the queued documents.
+func (b esBulk) close(ctx context.Context) error {
+	return b.Close(ctx)
+}
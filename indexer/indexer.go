@@ -0,0 +1,149 @@
+package indexer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/quay/clair-load-test/redact"
+	"github.com/quay/zlog"
+)
+
+// Enabled reports whether the configuration holds enough details to index documents.
+// It returns a boolean.
+func (c Config) Enabled() bool {
+	if c.Type == LocalIndexer {
+		return c.MetricsDirectory != ""
+	}
+	return c.URL != "" && c.Index != ""
+}
+
+// New creates an indexer for the configured backend and checks its connectivity.
+// Every document is scrubbed of secrets before it leaves the process.
+// It returns the indexer and an error if any during the execution.
+func New(ctx context.Context, c Config) (Indexer, error) {
+	i, err := newBackend(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return redacting{i}, nil
+}
+
+// newBackend creates the indexer of the configured backend.
+// It returns the indexer and an error if any during the execution.
+func newBackend(ctx context.Context, c Config) (Indexer, error) {
+	switch c.Type {
+	case ElasticIndexer:
+		zlog.Info(ctx).Str("url", c.URL).Msg("Creating elasticsearch indexer")
+		return newElastic(ctx, c)
+	case OpenSearchIndexer:
+		zlog.Info(ctx).Str("url", c.URL).Msg("Creating opensearch indexer")
+		return newOpenSearch(ctx, c)
+	case LocalIndexer:
+		zlog.Info(ctx).Str("directory", c.MetricsDirectory).Msg("Creating local indexer")
+		return newLocal(c)
+	default:
+		return nil, fmt.Errorf("unknown indexer %q: must be one among %s, %s or %s", c.Type, ElasticIndexer, OpenSearchIndexer, LocalIndexer)
+	}
+}
+
+// Type used to scrub secrets out of the documents before indexing them.
+type redacting struct {
+	Indexer
+}
+
+// Index redacts the documents and hands them over to the backend.
+// It returns a summary of the operation and an error if any during the execution.
+func (r redacting) Index(ctx context.Context, documents []interface{}, name string) (string, error) {
+	scrubbed := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		d, err := redact.Document(document)
+		if err != nil {
+			return "", fmt.Errorf("cannot encode document: %w", err)
+		}
+		scrubbed = append(scrubbed, d)
+	}
+	return r.Indexer.Index(ctx, scrubbed, name)
+}
+
+// readCABundle reads the PEM encoded certificate authorities, if configured.
+// It returns the bundle and an error if any during the execution.
+func readCABundle(c Config) ([]byte, error) {
+	if c.CABundle == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(c.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %w", err)
+	}
+	return b, nil
+}
+
+// documentID derives a stable identifier from the document contents.
+// It returns the hex encoded sha256 of the document.
+func documentID(doc []byte) string {
+	sum := sha256.Sum256(doc)
+	return hex.EncodeToString(sum[:])
+}
+
+// formatStats renders the per result counters of a bulk request.
+// It returns the summary string.
+func formatStats(stats map[string]int) string {
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%d", k, stats[k])
+	}
+	return b.String()
+}
+
+// encodeDocuments marshals documents ahead of a bulk request.
+// It returns the encoded documents and an error if any during the execution.
+func encodeDocuments(documents []interface{}) ([][]byte, error) {
+	encoded := make([][]byte, 0, len(documents))
+	for _, document := range documents {
+		j, err := json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode document: %w", err)
+		}
+		encoded = append(encoded, j)
+	}
+	return encoded, nil
+}
+
+// Type used to write documents to local files through go-commons.
+type local struct {
+	indexer *indexers.Indexer
+}
+
+// newLocal creates a go-commons local indexer.
+// It returns the indexer and an error if any during the execution.
+func newLocal(c Config) (*local, error) {
+	i, err := indexers.NewIndexer(indexers.IndexerConfig{
+		Type:             indexers.LocalIndexer,
+		MetricsDirectory: c.MetricsDirectory,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create local indexer: %w", err)
+	}
+	return &local{indexer: i}, nil
+}
+
+// Index writes the documents to a file named after the given name.
+// It returns a summary of the operation and an error if any during the execution.
+func (l *local) Index(ctx context.Context, documents []interface{}, name string) (string, error) {
+	if _, err := (*l.indexer).Index(documents, indexers.IndexingOpts{MetricName: name}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote %d documents to %s.json", len(documents), name), nil
+}
@@ -0,0 +1,107 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+
+	opensearch "github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+	"github.com/opensearch-project/opensearch-go/opensearchutil"
+)
+
+// Type used to talk to opensearch.
+type openSearch struct {
+	client *opensearch.Client
+}
+
+// newOpenSearch connects to opensearch and makes sure the index exists.
+// It returns the indexer and an error if any during the execution.
+func newOpenSearch(ctx context.Context, c Config) (*search, error) {
+	if c.Index == "" {
+		return nil, fmt.Errorf("index name not specified")
+	}
+	transport, caCert, err := newTransport(c)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if c.APIKey != "" {
+		header.Set("Authorization", "ApiKey "+c.APIKey)
+	}
+	client, err := opensearch.NewClient(opensearch.Config{
+		Addresses: []string{c.URL},
+		Username:  c.Username,
+		Password:  c.Password,
+		Header:    header,
+		CACert:    caCert,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating the opensearch client: %w", err)
+	}
+	return newSearch(ctx, "opensearch", openSearch{client}, c)
+}
+
+// osResponse reads a response of the opensearch client.
+// It returns the response and the error of the call.
+func osResponse(r *opensearchapi.Response, err error) (response, error) {
+	if err != nil {
+		return response{}, err
+	}
+	return readResponse(r.StatusCode, r.Body), nil
+}
+
+// health checks the cluster health.
+func (o openSearch) health(ctx context.Context) (response, error) {
+	return osResponse(o.client.Cluster.Health(o.client.Cluster.Health.WithContext(ctx)))
+}
+
+// exists looks the index up.
+func (o openSearch) exists(ctx context.Context, index string) (response, error) {
+	return osResponse(o.client.Indices.Exists([]string{index}, o.client.Indices.Exists.WithContext(ctx)))
+}
+
+// create creates the index.
+func (o openSearch) create(ctx context.Context, index string) (response, error) {
+	return osResponse(o.client.Indices.Create(index, o.client.Indices.Create.WithContext(ctx)))
+}
+
+// bulk starts a bulk indexer writing into the index.
+func (o openSearch) bulk(index string) (bulkIndexer, error) {
+	bi, err := opensearchutil.NewBulkIndexer(opensearchutil.BulkIndexerConfig{
+		Client:     o.client,
+		Index:      index,
+		FlushBytes: bulkFlushBytes,
+		NumWorkers: runtime.NumCPU(),
+		Timeout:    bulkTimeout,
+	})
+	return osBulk{bi}, err
+}
+
+// Type used to queue documents into the opensearch bulk indexer.
+type osBulk struct {
+	opensearchutil.BulkIndexer
+}
+
+// add queues a document, calling record with the result once it is indexed or failed.
+func (b osBulk) add(ctx context.Context, doc []byte, id string, record func(result string)) error {
+	return b.Add(ctx, opensearchutil.BulkIndexerItem{
+		Action:     "index",
+		Body:       bytes.NewReader(doc),
+		DocumentID: id,
+		OnSuccess: func(_ context.Context, _ opensearchutil.BulkIndexerItem, res opensearchutil.BulkIndexerResponseItem) {
+			record(res.Result)
+		},
+		OnFailure: func(_ context.Context, _ opensearchutil.BulkIndexerItem, _ opensearchutil.BulkIndexerResponseItem, _ error) {
+			record("failed")
+		},
+	})
+}
+
+// close flushes the queued documents.
+func (b osBulk) close(ctx context.Context) error {
+	return b.Close(ctx)
+}
@@ -0,0 +1,137 @@
+package indexer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants
+const (
+	bulkFlushBytes = 5e+6
+	bulkTimeout    = 10 * time.Minute
+)
+
+// Type used to hide the differences between the elasticsearch and opensearch clients, whose
+// APIs are generated from the same specification.
+type searchClient interface {
+	// health checks the cluster health.
+	health(ctx context.Context) (response, error)
+	// exists looks the index up.
+	exists(ctx context.Context, index string) (response, error)
+	// create creates the index.
+	create(ctx context.Context, index string) (response, error)
+	// bulk starts a bulk indexer writing into the index.
+	bulk(index string) (bulkIndexer, error)
+}
+
+// Type used to hold what matters of a response of either client.
+type response struct {
+	status int
+	body   string
+}
+
+// isError reports whether the response is an error one.
+func (r response) isError() bool {
+	return r.status > 299
+}
+
+// readResponse reads and closes the body of a response of either client.
+// It returns the response.
+func readResponse(status int, body io.ReadCloser) response {
+	defer body.Close()
+	b, _ := io.ReadAll(body)
+	return response{status: status, body: string(b)}
+}
+
+// Type used to hide the differences between the bulk indexers of both clients.
+type bulkIndexer interface {
+	// add queues a document, calling record with the result once it is indexed or failed.
+	add(ctx context.Context, doc []byte, id string, record func(result string)) error
+	// close flushes the queued documents.
+	close(ctx context.Context) error
+}
+
+// Type used to index documents into elasticsearch or opensearch.
+type search struct {
+	name   string
+	client searchClient
+	index  string
+}
+
+// newTransport creates the HTTP transport of the search clients.
+// It returns the transport and the CA bundle to trust, and an error if the bundle could not be read.
+func newTransport(c Config) (*http.Transport, []byte, error) {
+	caCert, err := readCABundle(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	return transport, caCert, nil
+}
+
+// newSearch checks the health of the cluster and makes sure the index exists.
+// It returns the indexer and an error if any during the execution.
+func newSearch(ctx context.Context, name string, client searchClient, c Config) (*search, error) {
+	r, err := client.health(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s health check failed: %w", name, err)
+	}
+	if r.status != http.StatusOK {
+		return nil, fmt.Errorf("unexpected %s status code: %d", name, r.status)
+	}
+	index := strings.ToLower(c.Index)
+	r, err = client.exists(ctx, index)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up index %s: %w", index, err)
+	}
+	if r.isError() {
+		r, err = client.create(ctx, index)
+		if err != nil {
+			return nil, fmt.Errorf("error creating index %s: %w", index, err)
+		}
+		if r.isError() {
+			return nil, fmt.Errorf("error creating index %s: [%d] %s", index, r.status, r.body)
+		}
+	}
+	return &search{name: name, client: client, index: index}, nil
+}
+
+// Index bulk indexes the documents.
+// It returns a summary of the operation and an error if any during the execution.
+func (s *search) Index(ctx context.Context, documents []interface{}, name string) (string, error) {
+	encoded, err := encodeDocuments(documents)
+	if err != nil {
+		return "", err
+	}
+	var mu sync.Mutex
+	stats := make(map[string]int)
+	record := func(result string) {
+		mu.Lock()
+		defer mu.Unlock()
+		stats[result]++
+	}
+	bi, err := s.client.bulk(s.index)
+	if err != nil {
+		return "", fmt.Errorf("error creating the bulk indexer: %w", err)
+	}
+	start := time.Now()
+	for _, doc := range encoded {
+		if err := bi.add(ctx, doc, documentID(doc), record); err != nil {
+			return "", fmt.Errorf("unexpected %s indexing error: %w", s.name, err)
+		}
+	}
+	if err := bi.close(ctx); err != nil {
+		return "", fmt.Errorf("unexpected %s error: %w", s.name, err)
+	}
+	if stats["failed"] > 0 {
+		return "", fmt.Errorf("%d of %d documents failed to index", stats["failed"], len(encoded))
+	}
+	return fmt.Sprintf("Indexing finished in %v:%s", time.Since(start).Truncate(time.Millisecond), formatStats(stats)), nil
+}
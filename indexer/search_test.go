@@ -0,0 +1,120 @@
+package indexer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSearch serves the few calls of the elasticsearch and opensearch APIs the indexers make,
+// failing the documents holding "fail".
+type fakeSearch struct {
+	mu      sync.Mutex
+	created []string
+	docs    map[string]string
+}
+
+func (f *fakeSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/":
+		fmt.Fprint(w, `{"version":{"number":"1.3.0","distribution":"opensearch"}}`)
+	case r.URL.Path == "/_cluster/health":
+		fmt.Fprint(w, `{"status":"green"}`)
+	case strings.HasSuffix(r.URL.Path, "/_bulk"):
+		var items []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				ID    string `json:"_id"`
+				Index string `json:"_index"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			scanner.Scan()
+			meta := action["index"]
+			if strings.Contains(scanner.Text(), "fail") {
+				items = append(items, fmt.Sprintf(`{"index":{"_id":%q,"status":400,"error":{"type":"mapper_parsing_exception"}}}`, meta.ID))
+				continue
+			}
+			f.docs[meta.ID] = scanner.Text()
+			items = append(items, fmt.Sprintf(`{"index":{"_id":%q,"result":"created","status":201}}`, meta.ID))
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	case r.Method == http.MethodHead:
+		for _, idx := range f.created {
+			if "/"+idx == r.URL.Path {
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPut:
+		f.created = append(f.created, strings.TrimPrefix(r.URL.Path, "/"))
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSearchIndexers(t *testing.T) {
+	for _, typ := range []string{ElasticIndexer, OpenSearchIndexer} {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			fake := &fakeSearch{docs: map[string]string{}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			c := Config{Type: typ, URL: srv.URL, Index: "Clair-Test"}
+
+			i, err := New(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := New(ctx, c); err != nil {
+				t.Fatal(err)
+			}
+			if len(fake.created) != 1 || fake.created[0] != "clair-test" {
+				t.Errorf("created indices %v, want the lower cased index once", fake.created)
+			}
+
+			docs := []interface{}{map[string]string{"phase": "a"}, map[string]string{"phase": "b"}}
+			summary, err := i.Index(ctx, docs, "run")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(summary, "created=2") || len(fake.docs) != 2 {
+				t.Errorf("got %q and %d documents, want 2 created", summary, len(fake.docs))
+			}
+			// Documents are identified by their contents, indexing them again overwrites them
+			if _, err := i.Index(ctx, docs, "run"); err != nil || len(fake.docs) != 2 {
+				t.Errorf("got %v and %d documents, want the same 2", err, len(fake.docs))
+			}
+
+			_, err = i.Index(ctx, []interface{}{map[string]string{"phase": "fail"}, map[string]string{"phase": "c"}}, "run")
+			if err == nil || !strings.Contains(err.Error(), "1 of 2 documents failed") {
+				t.Errorf("got %v, want a failed document", err)
+			}
+		})
+	}
+}
+
+func TestSearchUnhealthy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	for _, typ := range []string{ElasticIndexer, OpenSearchIndexer} {
+		_, err := New(context.Background(), Config{Type: typ, URL: srv.URL, Index: "clair-test"})
+		if err == nil || !(strings.Contains(err.Error(), "health check failed") || strings.Contains(err.Error(), "status code: 503")) {
+			t.Errorf("%s: got %v, want a health check failure", typ, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package indexer
+
+import (
+	"context"
+)
+
+// Types of indexers
+const (
+	ElasticIndexer    = "elastic"
+	OpenSearchIndexer = "opensearch"
+	LocalIndexer      = "local"
+)
+
+// Type used to configure the results indexer.
+type Config struct {
+	Type               string `json:"type"`
+	URL                string `json:"url"`
+	Index              string `json:"index"`
+	Username           string `json:"username,omitempty"`
+	Password           string `json:"-"`
+	APIKey             string `json:"-"`
+	CABundle           string `json:"ca_bundle,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	MetricsDirectory   string `json:"metrics_directory,omitempty"`
+}
+
+// Indexer stores documents in the configured backend.
+type Indexer interface {
+	// Index stores the documents under the given name.
+	// It returns a summary of the operation and an error if any during the execution.
+	Index(ctx context.Context, documents []interface{}, name string) (string, error)
+}
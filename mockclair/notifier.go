@@ -0,0 +1,227 @@
+package mockclair
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quay/zlog"
+)
+
+// Constants
+const (
+	callbackTimeout = 10 * time.Second
+)
+
+// Updaters update operations are made up for
+var mockUpdaters = []string{"ubuntu/updater/jammy", "debian/updater/bookworm", "RHEL9-rhel-9-including-unpatched"}
+
+// Type used to describe a run of an updater.
+type updateOperation struct {
+	Ref         string    `json:"ref"`
+	Updater     string    `json:"updater"`
+	Fingerprint string    `json:"fingerprint"`
+	Date        time.Time `json:"date"`
+	Kind        string    `json:"kind"`
+}
+
+// Type used to describe a change in the vulnerabilities affecting a manifest.
+type notification struct {
+	ID            string                 `json:"id"`
+	Manifest      string                 `json:"manifest"`
+	Reason        string                 `json:"reason"`
+	Vulnerability map[string]interface{} `json:"vulnerability"`
+}
+
+// newUpdateOperations makes up the three last runs of every updater, a day apart.
+// It returns the update operations by updater, newest first.
+func newUpdateOperations(now time.Time) map[string][]updateOperation {
+	ops := map[string][]updateOperation{}
+	for _, u := range mockUpdaters {
+		for d := 1; d <= 3; d++ {
+			ops[u] = append(ops[u], newUpdateOperation(u, now.Add(-time.Duration(d)*24*time.Hour)))
+		}
+	}
+	return ops
+}
+
+// newUpdateOperation makes up a run of an updater.
+func newUpdateOperation(updater string, date time.Time) updateOperation {
+	return updateOperation{
+		Ref:         uuid.New().String(),
+		Updater:     updater,
+		Fingerprint: strconv.FormatInt(date.Unix(), 10),
+		Date:        date.UTC(),
+		Kind:        "vulnerability",
+	}
+}
+
+// getUpdateOperations lists the update operations of every updater, only the latest ones with ?latest=true.
+func (s *Server) getUpdateOperations(w http.ResponseWriter, r *http.Request, _ string) {
+	latest := r.URL.Query().Get("latest") == "true"
+	out := map[string][]updateOperation{}
+	s.mu.RLock()
+	for u, ops := range s.operations {
+		if latest {
+			ops = ops[:1]
+		}
+		out[u] = append([]updateOperation(nil), ops...)
+	}
+	s.mu.RUnlock()
+	writeJSON(w, http.StatusOK, out)
+}
+
+// getUpdateDiff returns the vulnerabilities added between two update operations of an updater.
+func (s *Server) getUpdateDiff(w http.ResponseWriter, r *http.Request, _ string) {
+	cur, prev := r.URL.Query().Get("cur"), r.URL.Query().Get("prev")
+	if cur == "" {
+		writeError(w, http.StatusBadRequest, "bad-request", "cur is required")
+		return
+	}
+	var curOp, prevOp *updateOperation
+	s.mu.RLock()
+	for _, ops := range s.operations {
+		for i := range ops {
+			switch ops[i].Ref {
+			case cur:
+				op := ops[i]
+				curOp = &op
+			case prev:
+				op := ops[i]
+				prevOp = &op
+			}
+		}
+	}
+	s.mu.RUnlock()
+	if curOp == nil || (prev != "" && prevOp == nil) {
+		writeError(w, http.StatusNotFound, "not-found", "update operation not found")
+		return
+	}
+	rnd := rand.New(rand.NewSource(curOp.Date.UnixNano()))
+	dist := mockDistributions[rnd.Intn(len(mockDistributions))]
+	added := make([]map[string]interface{}, 0, 50)
+	for i := 0; i < cap(added); i++ {
+		added = append(added, newVulnerability(rnd, mockPackages[rnd.Intn(len(mockPackages))], dist))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"prev": prevOp, "cur": curOp, "added": added, "removed": []interface{}{}})
+}
+
+// getNotification returns a page of a notification, following the next cursor.
+func (s *Server) getNotification(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.RLock()
+	all, ok := s.notifications[id]
+	s.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "not-found", "notification not found")
+		return
+	}
+	size := queryInt(r, "page_size", 500)
+	from, _ := strconv.Atoi(r.URL.Query().Get("next"))
+	if from < 0 || from > len(all) {
+		from = len(all)
+	}
+	to := from + size
+	if to > len(all) {
+		to = len(all)
+	}
+	page := map[string]interface{}{"size": size}
+	if to < len(all) {
+		page["next"] = strconv.Itoa(to)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"page": page, "notifications": all[from:to]})
+}
+
+// deleteNotification forgets a notification.
+func (s *Server) deleteNotification(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	delete(s.notifications, id)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// Notify makes an updater run every notification interval, creates a notification for the
+// manifests it affects and calls the webhook back, until ctx is done.
+func (s *Server) Notify(ctx context.Context) {
+	if s.conf.Webhook == "" || s.conf.NotificationInterval <= 0 {
+		return
+	}
+	client := &http.Client{Timeout: callbackTimeout}
+	ticker := time.NewTicker(s.conf.NotificationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			id := s.newNotification(now)
+			if err := s.callback(ctx, client, id); err != nil {
+				zlog.Warn(ctx).Err(err).Str("notification_id", id).Msg("could not call the webhook back")
+			}
+		}
+	}
+}
+
+// newNotification records a new run of a random updater and the notification it causes,
+// about the indexed manifests or made up ones when none was indexed.
+// It returns the ID of the notification.
+func (s *Server) newNotification(now time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updater := mockUpdaters[rand.Intn(len(mockUpdaters))]
+	s.operations[updater] = append([]updateOperation{newUpdateOperation(updater, now)}, s.operations[updater]...)
+	hashes := make([]string, 0, len(s.reports))
+	for h := range s.reports {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	id := uuid.New().String()
+	dist := mockDistributions[rand.Intn(len(mockDistributions))]
+	ns := make([]notification, 0, s.conf.NotificationSize)
+	for i := 0; i < s.conf.NotificationSize; i++ {
+		manifest := fmt.Sprintf("sha256:%064x", rand.Uint64())
+		if len(hashes) > 0 {
+			manifest = hashes[rand.Intn(len(hashes))]
+		}
+		v := newVulnerability(rand.New(rand.NewSource(rand.Int63())), mockPackages[rand.Intn(len(mockPackages))], dist)
+		delete(v, "id")
+		delete(v, "updater")
+		delete(v, "issued")
+		ns = append(ns, notification{ID: strconv.Itoa(i), Manifest: manifest, Reason: "added", Vulnerability: v})
+	}
+	s.notifications[id] = ns
+	return id
+}
+
+// callback tells the webhook a notification is ready.
+// It returns an error if the webhook could not be reached or did not accept it.
+func (s *Server) callback(ctx context.Context, client *http.Client, id string) error {
+	body, err := json.Marshal(map[string]string{
+		"notification_id": id,
+		"callback":        strings.TrimRight(s.conf.URL, "/") + "/notifier/api/v1/notification/" + id,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package mockclair
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// States of an index report
+const (
+	indexInProgress = "IndexingLayers"
+	indexFinished   = "IndexFinished"
+)
+
+// Packages, versions and distributions index reports are made of, as found in common base images.
+var (
+	mockPackages = []string{
+		"openssl", "libssl3", "glibc", "libc6", "zlib1g", "curl", "libcurl4", "bash", "coreutils", "tar",
+		"gzip", "xz-utils", "libxml2", "sqlite3", "perl-base", "python3", "systemd", "util-linux", "openssh-client", "gnupg",
+		"libgcrypt20", "libtasn1-6", "ncurses-base", "sed", "grep", "dpkg", "apt", "login", "passwd", "libpcre3",
+	}
+	mockDistributions = []map[string]string{
+		{"did": "ubuntu", "name": "Ubuntu", "version": "22.04 (Jammy)", "version_code_name": "jammy", "version_id": "22.04", "pretty_name": "Ubuntu 22.04 LTS"},
+		{"did": "debian", "name": "Debian GNU/Linux", "version": "12 (bookworm)", "version_code_name": "bookworm", "version_id": "12", "pretty_name": "Debian GNU/Linux 12 (bookworm)"},
+		{"did": "rhel", "name": "Red Hat Enterprise Linux Server", "version": "9", "version_id": "9", "pretty_name": "Red Hat Enterprise Linux Server 9"},
+	}
+	mockSeverities = []string{"Low", "Medium", "High", "Critical"}
+)
+
+// Type used to keep an index report in memory.
+type indexReport struct {
+	hash     string
+	posted   time.Time
+	dist     map[string]string
+	packages []map[string]interface{}
+	vulns    map[string][]map[string]interface{}
+}
+
+// newIndexReport makes up the contents of a manifest, the same every time for the same hash.
+// It returns the index report.
+func newIndexReport(hash string, now time.Time) *indexReport {
+	h := fnv.New64a()
+	h.Write([]byte(hash))
+	rnd := rand.New(rand.NewSource(int64(h.Sum64())))
+	dist := mockDistributions[rnd.Intn(len(mockDistributions))]
+	r := &indexReport{hash: hash, posted: now, dist: dist, vulns: map[string][]map[string]interface{}{}}
+	for i, n := range rnd.Perm(len(mockPackages))[:5+rnd.Intn(len(mockPackages)-5)] {
+		id := strconv.Itoa(i + 1)
+		pkg := map[string]interface{}{
+			"id":      id,
+			"name":    mockPackages[n],
+			"version": fmt.Sprintf("%d.%d.%d-%d", 1+rnd.Intn(3), rnd.Intn(20), rnd.Intn(20), 1+rnd.Intn(5)),
+			"kind":    "binary",
+		}
+		r.packages = append(r.packages, pkg)
+		// About a third of the packages are vulnerable
+		if rnd.Intn(3) > 0 {
+			continue
+		}
+		for v := 0; v < 1+rnd.Intn(3); v++ {
+			r.vulns[id] = append(r.vulns[id], newVulnerability(rnd, mockPackages[n], dist))
+		}
+	}
+	return r
+}
+
+// newVulnerability makes up a vulnerability of a package, shaped like the ones clair's updaters store.
+// It returns the vulnerability.
+func newVulnerability(rnd *rand.Rand, pkg string, dist map[string]string) map[string]interface{} {
+	name := fmt.Sprintf("CVE-%d-%05d", 2018+rnd.Intn(7), 10000+rnd.Intn(90000))
+	severity := mockSeverities[rnd.Intn(len(mockSeverities))]
+	return map[string]interface{}{
+		"id":                  strconv.FormatUint(rnd.Uint64()%10000000, 10),
+		"updater":             dist["did"] + "-updater",
+		"name":                name,
+		"description":         fmt.Sprintf("%s in %s", name, pkg),
+		"issued":              time.Date(2018+rnd.Intn(7), time.Month(1+rnd.Intn(12)), 1+rnd.Intn(28), 0, 0, 0, 0, time.UTC),
+		"links":               "https://www.cve.org/CVERecord?id=" + name,
+		"severity":            severity,
+		"normalized_severity": severity,
+		"package":             map[string]interface{}{"id": "", "name": pkg, "version": "", "kind": "binary"},
+		"distribution":        distribution(dist),
+		"repository":          map[string]interface{}{},
+		"fixed_in_version":    fmt.Sprintf("%d.%d.%d-%d", 1+rnd.Intn(3), rnd.Intn(20), rnd.Intn(20), 1+rnd.Intn(5)),
+	}
+}
+
+// distribution renders a distribution the way clair does.
+func distribution(dist map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                "1",
+		"did":               dist["did"],
+		"name":              dist["name"],
+		"version":           dist["version"],
+		"version_code_name": dist["version_code_name"],
+		"version_id":        dist["version_id"],
+		"pretty_name":       dist["pretty_name"],
+	}
+}
+
+// state tells whether the report is still being indexed.
+func (r *indexReport) state(now time.Time, delay time.Duration) string {
+	if now.Sub(r.posted) < delay {
+		return indexInProgress
+	}
+	return indexFinished
+}
+
+// index renders the index report.
+func (r *indexReport) index(state string) map[string]interface{} {
+	packages := map[string]interface{}{}
+	environments := map[string]interface{}{}
+	for _, p := range r.packages {
+		id := p["id"].(string)
+		packages[id] = p
+		environments[id] = []map[string]string{{"package_db": "var/lib/dpkg/status", "introduced_in": r.hash, "distribution_id": "1"}}
+	}
+	return map[string]interface{}{
+		"manifest_hash": r.hash,
+		"state":         state,
+		"packages":      packages,
+		"distributions": map[string]interface{}{"1": distribution(r.dist)},
+		"repository":    map[string]interface{}{},
+		"environments":  environments,
+		"success":       state == indexFinished,
+		"err":           "",
+	}
+}
+
+// vulnerability renders the vulnerability report.
+func (r *indexReport) vulnerability() map[string]interface{} {
+	report := r.index(indexFinished)
+	delete(report, "state")
+	delete(report, "success")
+	delete(report, "err")
+	vulns := map[string]interface{}{}
+	byPackage := map[string][]string{}
+	for id, vs := range r.vulns {
+		for _, v := range vs {
+			vulns[v["id"].(string)] = v
+			byPackage[id] = append(byPackage[id], v["id"].(string))
+		}
+	}
+	report["vulnerabilities"] = vulns
+	report["package_vulnerabilities"] = byPackage
+	report["enrichments"] = map[string]interface{}{}
+	return report
+}
+
+// postIndexReport indexes a manifest, in progress for the configured delay.
+func (s *Server) postIndexReport(w http.ResponseWriter, r *http.Request, _ string) {
+	var m struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil || m.Hash == "" {
+		writeError(w, http.StatusBadRequest, "bad-request", "could not decode manifest")
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	report, ok := s.reports[m.Hash]
+	if !ok {
+		report = newIndexReport(m.Hash, now)
+		s.reports[m.Hash] = report
+		s.state = newState()
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, report.index(report.state(now, s.conf.IndexDelay)))
+}
+
+// getIndexReport returns the index report of a manifest.
+func (s *Server) getIndexReport(w http.ResponseWriter, r *http.Request, hash string) {
+	s.mu.RLock()
+	report, ok := s.reports[hash]
+	s.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "not-found", "index report not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, report.index(report.state(time.Now(), s.conf.IndexDelay)))
+}
+
+// deleteIndexReport forgets the index report of a manifest.
+func (s *Server) deleteIndexReport(w http.ResponseWriter, r *http.Request, hash string) {
+	s.mu.Lock()
+	delete(s.reports, hash)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getIndexState returns the state of the indexer, which changes whenever a manifest is indexed.
+func (s *Server) getIndexState(w http.ResponseWriter, r *http.Request, _ string) {
+	s.mu.RLock()
+	state := s.state
+	s.mu.RUnlock()
+	w.Header().Set("Etag", `"`+state+`"`)
+	writeJSON(w, http.StatusOK, map[string]string{"state": state})
+}
+
+// getVulnerabilityReport returns the vulnerability report of an indexed manifest.
+func (s *Server) getVulnerabilityReport(w http.ResponseWriter, r *http.Request, hash string) {
+	s.mu.RLock()
+	report, ok := s.reports[hash]
+	s.mu.RUnlock()
+	if !ok || report.state(time.Now(), s.conf.IndexDelay) != indexFinished {
+		writeError(w, http.StatusNotFound, "not-found", "index report not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, report.vulnerability())
+}
+
+// postAffectedManifest returns the manifests holding a package of the given vulnerabilities.
+func (s *Server) postAffectedManifest(w http.ResponseWriter, r *http.Request, _ string) {
+	var req struct {
+		Vulnerabilities []map[string]interface{} `json:"vulnerabilities"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "bad-request", "could not decode vulnerabilities")
+		return
+	}
+	vulns := map[string]interface{}{}
+	affected := map[string][]string{}
+	s.mu.RLock()
+	for i, v := range req.Vulnerabilities {
+		id, _ := v["id"].(string)
+		if id == "" {
+			id = strconv.Itoa(i)
+		}
+		pkg, _ := v["package"].(map[string]interface{})
+		name, _ := pkg["name"].(string)
+		for hash, report := range s.reports {
+			for _, p := range report.packages {
+				if p["name"] == name {
+					vulns[id] = v
+					affected[hash] = append(affected[hash], id)
+					break
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"vulnerabilities": vulns, "vulnerable_manifests": affected})
+}
+
+// newState returns a new opaque indexer state.
+func newState() string {
+	return strconv.FormatUint(rand.Uint64(), 16)
+}
@@ -0,0 +1,242 @@
+package mockclair
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quay/clair-load-test/auth"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Type used to serve the indexer, matcher and notifier APIs of clair from memory.
+type Server struct {
+	conf Config
+
+	mu            sync.RWMutex
+	reports       map[string]*indexReport
+	state         string
+	operations    map[string][]updateOperation
+	notifications map[string][]notification
+}
+
+// New creates a mock clair server.
+// It returns the server and an error if the configuration is not valid.
+func New(c Config) (*Server, error) {
+	behaviors := []Behavior{c.Default}
+	for name, b := range c.Routes {
+		if !validRoute(name) {
+			return nil, fmt.Errorf("unknown route %q: must be one among %v", name, Routes)
+		}
+		behaviors = append(behaviors, b)
+	}
+	for _, b := range behaviors {
+		if err := b.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if c.NotificationSize <= 0 {
+		c.NotificationSize = 100
+	}
+	return &Server{
+		conf:          c,
+		reports:       map[string]*indexReport{},
+		state:         newState(),
+		notifications: map[string][]notification{},
+		operations:    newUpdateOperations(time.Now()),
+	}, nil
+}
+
+// validRoute tells whether behaviours can be set for the named route.
+func validRoute(name string) bool {
+	for _, r := range Routes {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validate makes sure a behaviour can be honoured.
+// It returns an error if the distribution is unknown or the error rate out of range.
+func (b Behavior) validate() error {
+	switch b.Latency.Distribution {
+	case "", DistributionFixed, DistributionUniform, DistributionNormal, DistributionExponential:
+	default:
+		return fmt.Errorf("unknown latency distribution %q: must be one among %v", b.Latency.Distribution,
+			[]string{DistributionFixed, DistributionUniform, DistributionNormal, DistributionExponential})
+	}
+	if b.ErrorRate < 0 || b.ErrorRate > 1 {
+		return fmt.Errorf("error rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// ParseLatency parses a latency such as "200ms", "uniform:200ms:50ms" or "normal:200ms:50ms".
+// It returns the latency and an error if it is malformed.
+func ParseLatency(s string) (Latency, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	l := Latency{Distribution: DistributionFixed}
+	if len(parts) > 1 {
+		l.Distribution, parts = parts[0], parts[1:]
+	}
+	if len(parts) > 2 || parts[0] == "" {
+		return Latency{}, fmt.Errorf("invalid latency %q: must look like normal:200ms:50ms", s)
+	}
+	var err error
+	if l.Mean, err = time.ParseDuration(parts[0]); err != nil {
+		return Latency{}, fmt.Errorf("invalid latency %q: %w", s, err)
+	}
+	if len(parts) == 2 {
+		if l.Spread, err = time.ParseDuration(parts[1]); err != nil {
+			return Latency{}, fmt.Errorf("invalid latency %q: %w", s, err)
+		}
+	}
+	return l, (Behavior{Latency: l}).validate()
+}
+
+// sample draws a latency from the distribution.
+// It returns the latency, never negative.
+func (l Latency) sample() time.Duration {
+	var d float64
+	mean, spread := float64(l.Mean), float64(l.Spread)
+	switch l.Distribution {
+	case DistributionUniform:
+		d = mean - spread + rand.Float64()*2*spread
+	case DistributionNormal:
+		d = mean + rand.NormFloat64()*spread
+	case DistributionExponential:
+		d = rand.ExpFloat64() * mean
+	default:
+		d = mean
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+// behavior returns the behaviour of a route.
+func (s *Server) behavior(route string) Behavior {
+	if b, ok := s.conf.Routes[route]; ok {
+		return b
+	}
+	return s.conf.Default
+}
+
+// ServeHTTP routes a request to its API, after checking its token and applying the
+// latency and the errors of the route.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e, id, ok := s.route(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not-found", "no such endpoint: "+r.Method+" "+r.URL.Path)
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing token")
+		return
+	}
+	b := s.behavior(e.route)
+	select {
+	case <-time.After(b.Latency.sample()):
+	case <-r.Context().Done():
+		return
+	}
+	if b.ErrorRate > 0 && rand.Float64() < b.ErrorRate {
+		code := b.ErrorCode
+		if code == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		writeError(w, code, "injected", "error injected by mock-clair")
+		return
+	}
+	e.handler(w, r, id)
+}
+
+// Type used to map a method and a path onto the handler of an API.
+type endpoint struct {
+	method  string
+	path    string
+	route   string
+	withID  bool
+	handler func(http.ResponseWriter, *http.Request, string)
+}
+
+// endpoints lists the APIs served.
+func (s *Server) endpoints() []endpoint {
+	return []endpoint{
+		{http.MethodPost, "/indexer/api/v1/index_report", RouteIndexReport, false, s.postIndexReport},
+		{http.MethodGet, "/indexer/api/v1/index_report", RouteIndexReport, true, s.getIndexReport},
+		{http.MethodDelete, "/indexer/api/v1/index_report", RouteIndexReport, true, s.deleteIndexReport},
+		{http.MethodGet, "/indexer/api/v1/index_state", RouteIndexState, false, s.getIndexState},
+		{http.MethodPost, "/indexer/api/v1/internal/affected_manifest", RouteAffectedManifest, false, s.postAffectedManifest},
+		{http.MethodGet, "/matcher/api/v1/vulnerability_report", RouteVulnerabilityReport, true, s.getVulnerabilityReport},
+		{http.MethodGet, "/matcher/api/v1/internal/update_operation", RouteUpdateOperation, false, s.getUpdateOperations},
+		{http.MethodGet, "/matcher/api/v1/internal/update_diff", RouteUpdateDiff, false, s.getUpdateDiff},
+		{http.MethodGet, "/notifier/api/v1/notification", RouteNotification, true, s.getNotification},
+		{http.MethodDelete, "/notifier/api/v1/notification", RouteNotification, true, s.deleteNotification},
+	}
+}
+
+// route works out the endpoint of a request and the ID in its path, if any.
+// It returns the endpoint, the ID and whether the request matches any API.
+func (s *Server) route(r *http.Request) (endpoint, string, bool) {
+	p := strings.TrimRight(r.URL.Path, "/")
+	// Double slashes come from hosts configured with a trailing slash
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	for _, e := range s.endpoints() {
+		if e.method != r.Method {
+			continue
+		}
+		if !e.withID {
+			if p == e.path {
+				return e, "", true
+			}
+			continue
+		}
+		if id := strings.TrimPrefix(p, e.path+"/"); id != p && id != "" && !strings.Contains(id, "/") {
+			return e, id, true
+		}
+	}
+	return endpoint{}, "", false
+}
+
+// authorized checks the token of a request against the PSK, when one is configured.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.conf.PSK == "" {
+		return true
+	}
+	tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tok == "" || tok == r.Header.Get("Authorization") {
+		return false
+	}
+	problems, err := auth.Verify(tok, s.conf.PSK, auth.Config{}, jwt.DefaultLeeway, time.Now())
+	return err == nil && len(problems) == 0
+}
+
+// writeJSON answers with a JSON document.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with an error the way clair does.
+func writeError(w http.ResponseWriter, code int, kind, msg string) {
+	writeJSON(w, code, map[string]string{"code": kind, "message": msg})
+}
+
+// queryInt reads a positive integer query parameter.
+// It returns the value, or def when missing or invalid.
+func queryInt(r *http.Request, name string, def int) int {
+	v, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,59 @@
+package mockclair
+
+import (
+	"time"
+)
+
+// Routes of the mocked APIs, which behaviours can be set for
+const (
+	RouteIndexReport         = "index_report"
+	RouteIndexState          = "index_state"
+	RouteVulnerabilityReport = "vulnerability_report"
+	RouteAffectedManifest    = "affected_manifest"
+	RouteUpdateOperation     = "update_operation"
+	RouteUpdateDiff          = "update_diff"
+	RouteNotification        = "notification"
+)
+
+// Latency distributions
+const (
+	DistributionFixed       = "fixed"
+	DistributionUniform     = "uniform"
+	DistributionNormal      = "normal"
+	DistributionExponential = "exponential"
+)
+
+// Routes lists the routes behaviours can be set for.
+var Routes = []string{RouteIndexReport, RouteIndexState, RouteVulnerabilityReport, RouteAffectedManifest, RouteUpdateOperation, RouteUpdateDiff, RouteNotification}
+
+// Type used to describe how long responses take.
+// Spread is the half width of uniform latencies and the standard deviation of normal ones.
+type Latency struct {
+	Distribution string        `json:"distribution"`
+	Mean         time.Duration `json:"mean"`
+	Spread       time.Duration `json:"spread"`
+}
+
+// Type used to describe how a route answers: after how long and how often it fails, with which status code.
+type Behavior struct {
+	Latency   Latency `json:"latency"`
+	ErrorRate float64 `json:"error_rate"`
+	ErrorCode int     `json:"error_code"`
+}
+
+// Type used to configure the mock server.
+type Config struct {
+	// PSK, when set, is the key every request's JWT must be signed with
+	PSK string `json:"-"`
+	// Default is the behaviour of the routes without their own
+	Default Behavior            `json:"default"`
+	Routes  map[string]Behavior `json:"routes,omitempty"`
+	// IndexDelay is how long index reports stay in progress after being posted
+	IndexDelay time.Duration `json:"index_delay"`
+	// URL is where the server is reached, for the callbacks of notifications
+	URL string `json:"url"`
+	// Webhook, when set, is called back for every new notification
+	Webhook              string        `json:"webhook,omitempty"`
+	NotificationInterval time.Duration `json:"notification_interval"`
+	NotificationSize     int           `json:"notification_size"`
+}
@@ -0,0 +1,185 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quay/clair-load-test/redact"
+)
+
+// Constants
+const maxLineSize = 64 * 1024 * 1024
+
+// Headers never recorded: credentials, and the ones the transport sets on its own
+var skippedHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie",
+	"Connection", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Content-Length", "Accept-Encoding", "X-Forwarded-For",
+}
+
+// Type used to forward requests to clair, writing every one of them down as it goes.
+type Recorder struct {
+	proxy *httputil.ReverseProxy
+
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	count uint64
+	err   error
+}
+
+// NewRecorder creates a recorder forwarding requests to upstream and writing them to w.
+// It returns the recorder and an error if upstream is not a valid URL.
+func NewRecorder(upstream string, w io.Writer) (*Recorder, error) {
+	u, err := url.Parse(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: must look like http://clair:6060", upstream)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = u.Host
+	}
+	return &Recorder{proxy: proxy, enc: json.NewEncoder(w)}, nil
+}
+
+// ServeHTTP forwards a request, then records it along with the status and the latency of its response.
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	rec.mu.Lock()
+	if rec.start.IsZero() {
+		rec.start = now
+	}
+	offset := now.Sub(rec.start)
+	rec.mu.Unlock()
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			http.Error(w, "could not read the request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	header := r.Header.Clone()
+	for _, h := range skippedHeaders {
+		header.Del(h)
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	rec.proxy.ServeHTTP(sw, r)
+	rec.write(Entry{
+		Offset:  offset,
+		Time:    now.UTC(),
+		Method:  r.Method,
+		Path:    r.URL.RequestURI(),
+		Header:  header,
+		Body:    scrubBody(body),
+		Status:  sw.status,
+		Latency: time.Since(now),
+	})
+}
+
+// scrubBody removes the credentials a request body may carry: the registry headers of the
+// layers of a manifest, then anything else redact recognises as a secret.
+// It returns the body to record.
+func scrubBody(body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(body, &manifest); err == nil {
+		if layers, ok := manifest["layers"].([]interface{}); ok {
+			for _, l := range layers {
+				if layer, ok := l.(map[string]interface{}); ok {
+					delete(layer, "headers")
+				}
+			}
+			if b, err := json.Marshal(manifest); err == nil {
+				body = b
+			}
+		}
+	}
+	return []byte(redact.String(string(body)))
+}
+
+// write appends an entry to the recording.
+func (rec *Recorder) write(e Entry) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.enc.Encode(e); err != nil {
+		rec.err = err
+		return
+	}
+	rec.count++
+}
+
+// Recorded returns how many requests were recorded so far and the last error writing them, if any.
+func (rec *Recorder) Recorded() (uint64, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.count, rec.err
+}
+
+// Type used to catch the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before sending it.
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush sends what was written so far to the client.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the response writer being watched.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Read loads a recording.
+// It returns its entries ordered by offset and an error if the file could not be read or parsed.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open recording: %w", err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid recording %s at line %d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read recording: %w", err)
+	}
+	// Requests are written once answered, which is not always the order they came in
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+	return entries, nil
+}
@@ -0,0 +1,20 @@
+package recording
+
+import (
+	"net/http"
+	"time"
+)
+
+// Type used to record a request that went through the recorder, one JSON line each.
+// Offset is the time the request arrived at, since the first one recorded, and Path
+// carries the query string. Credentials are never recorded.
+type Entry struct {
+	Offset  time.Duration `json:"offset"`
+	Time    time.Time     `json:"time"`
+	Method  string        `json:"method"`
+	Path    string        `json:"path"`
+	Header  http.Header   `json:"header,omitempty"`
+	Body    []byte        `json:"body,omitempty"`
+	Status  int           `json:"status"`
+	Latency time.Duration `json:"latency"`
+}
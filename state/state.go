@@ -0,0 +1,96 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Path builds the path of the state file of a run.
+// It returns the path string.
+func Path(dir, runID string) string {
+	return filepath.Join(dir, "clair-load-test-"+runID+".json")
+}
+
+// Save writes the state file, replacing it whole so that a crash never leaves it truncated.
+// It returns an error if any during the execution.
+func Save(path string, s *State) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not write state file: %w", err)
+	}
+	return nil
+}
+
+// Load reads a state file.
+// It returns the state and an error if the file could not be read or decoded.
+func Load(path string) (*State, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read state file: %w", err)
+	}
+	var s State
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("could not decode state file %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Remove deletes the state file once nothing is left to clean up.
+// It returns an error if the file exists but could not be removed.
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not remove state file: %w", err)
+	}
+	return nil
+}
+
+// LoadHashes reads manifest hashes either from a state file or from a plain list,
+// one hash per line, where blank lines and lines starting with # are ignored.
+// It returns the hashes and an error if the file could not be read or holds none.
+func LoadHashes(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest hashes: %w", err)
+	}
+	var hashes []string
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		var s State
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return nil, fmt.Errorf("could not decode state file %s: %w", path, err)
+		}
+		hashes = s.ManifestHashes
+	} else {
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			hashes = append(hashes, line)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("no manifest hashes found in %s", path)
+	}
+	return hashes, nil
+}
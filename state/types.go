@@ -0,0 +1,13 @@
+package state
+
+import (
+	"time"
+)
+
+// Type used to remember the index reports a run created, so they can be deleted later.
+type State struct {
+	RunID          string    `json:"run_id"`
+	Host           string    `json:"host"`
+	CreatedAt      time.Time `json:"created_at"`
+	ManifestHashes []string  `json:"manifest_hashes"`
+}